@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceClusterInfo() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceClusterInfoRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"server_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"major": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"minor": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"platform": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"node_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// dataSourceClusterInfoRead reads the version of the API server and counts the
+// nodes of the cluster.
+func dataSourceClusterInfoRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	output := func(args ...string) (string, error) {
+		var stdout *bytes.Buffer
+		err := retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+			cmd := kubectl(m, kubeconfig, args...)
+			stdout = &bytes.Buffer{}
+			cmd.Stdout = stdout
+			if err := run(cmd); err != nil {
+				return retryError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		return stdout.String(), nil
+	}
+
+	version, err := output("version", "-o", "json")
+	if err != nil {
+		return err
+	}
+	var data struct {
+		ServerVersion struct {
+			Major      string `json:"major"`
+			Minor      string `json:"minor"`
+			GitVersion string `json:"gitVersion"`
+			Platform   string `json:"platform"`
+		} `json:"serverVersion"`
+	}
+	if err := json.Unmarshal([]byte(version), &data); err != nil {
+		return fmt.Errorf("decoding kubectl version: %v", err)
+	}
+	if data.ServerVersion.GitVersion == "" {
+		return fmt.Errorf("could not parse server version from %s", version)
+	}
+
+	nodes, err := output("get", "nodes", "-o", "name")
+	if err != nil {
+		return err
+	}
+
+	d.SetId(data.ServerVersion.GitVersion)
+	for key, value := range map[string]interface{}{
+		"server_version": data.ServerVersion.GitVersion,
+		"major":          data.ServerVersion.Major,
+		"minor":          data.ServerVersion.Minor,
+		"platform":       data.ServerVersion.Platform,
+		"node_count":     len(strings.Fields(nodes)),
+	} {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceExec() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceExecRead,
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"container": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"command": &schema.Schema{
+				Type:     schema.TypeList,
+				Required: true,
+				MinItems: 1,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"stdout": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"stderr": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"exit_code": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// dataSourceExecRead runs the command in the pod once, as it may not be safe to
+// retry. A non-zero exit code is exported rather than failing the read.
+func dataSourceExecRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	name := d.Get("name").(string)
+	namespace := d.Get("namespace").(string)
+	args := []string{"exec", name}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if container := d.Get("container").(string); container != "" {
+		args = append(args, "-c", container)
+	}
+	args = append(args, "--")
+	for _, arg := range d.Get("command").([]interface{}) {
+		args = append(args, arg.(string))
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := kubectl(m, kubeconfig, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return fmt.Errorf("%s: %v", commandString(cmd), err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	d.SetId(namespace + "/" + name)
+	for key, value := range map[string]interface{}{
+		"stdout":    stdout.String(),
+		"stderr":    stderr.String(),
+		"exit_code": exitCode,
+	} {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
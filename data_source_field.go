@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceField() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceFieldRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"api_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"kind": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"jsonpath": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"value": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+		},
+	}
+}
+
+func dataSourceFieldRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	obj := manifestObject{
+		apiVersion: d.Get("api_version").(string),
+		kind:       d.Get("kind").(string),
+		namespace:  d.Get("namespace").(string),
+		name:       d.Get("name").(string),
+	}
+	args := []string{"get", "-o", "jsonpath=" + jsonpathTemplate(d.Get("jsonpath").(string)), obj.resource()}
+	if obj.namespace != "" {
+		args = append(args, "-n", obj.namespace)
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(obj.id() + ":" + d.Get("jsonpath").(string))
+	if err := d.Set("value", stdout.String()); err != nil {
+		return fmt.Errorf("setting value: %v", err)
+	}
+	return nil
+}
+
+// jsonpathTemplate returns the kubectl JSONPath template of path. Plain paths
+// such as .data.token are accepted as well as templates.
+func jsonpathTemplate(path string) string {
+	if !strings.Contains(path, "{") {
+		return "{" + path + "}"
+	}
+	return path
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceManifestValidation() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceManifestValidationRead,
+
+		Schema: map[string]*schema.Schema{
+			"content": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"valid": &schema.Schema{
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"error": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// dataSourceManifestValidationRead validates the manifest with a client-side
+// dry run of kubectl apply, which never changes the cluster. An invalid
+// manifest is reported through the valid and error attributes rather than
+// failing the read, other failures such as an unreachable API server do fail
+// it.
+func dataSourceManifestValidationRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	content := d.Get("content").(string)
+
+	cmd := kubectl(m, kubeconfig, "apply", m.(*config).clientDryRunArg(), "--validate=true", "-f", "-")
+	cmd.Stdin = strings.NewReader(content)
+	validationErr := run(cmd)
+	if validationErr != nil && !isValidationError(validationErr) {
+		return fmt.Errorf("validating manifest: %v", validationErr)
+	}
+
+	d.SetId(manifestHash(content))
+	if err := d.Set("valid", validationErr == nil); err != nil {
+		return fmt.Errorf("setting valid: %v", err)
+	}
+	message := ""
+	if validationErr != nil {
+		message = validationErr.Error()
+	}
+	if err := d.Set("error", message); err != nil {
+		return fmt.Errorf("setting error: %v", err)
+	}
+	return nil
+}
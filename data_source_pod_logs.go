@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+func dataSourcePodLogs() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourcePodLogsRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"container": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"tail": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"logs": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourcePodLogsRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	name := d.Get("name").(string)
+	namespace := d.Get("namespace").(string)
+	args := []string{"logs", name, "--tail", strconv.Itoa(d.Get("tail").(int))}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if container := d.Get("container").(string); container != "" {
+		args = append(args, "-c", container)
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(namespace + "/" + name)
+	if err := d.Set("logs", stdout.String()); err != nil {
+		return fmt.Errorf("setting logs: %v", err)
+	}
+	return nil
+}
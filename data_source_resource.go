@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceResource() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceResourceRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"api_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"kind": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"manifest": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"uid": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"labels": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"annotations": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"data": &schema.Schema{
+				Type:      schema.TypeMap,
+				Computed:  true,
+				Sensitive: true,
+				Elem:      &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
+func dataSourceResourceRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	obj := manifestObject{
+		apiVersion: d.Get("api_version").(string),
+		kind:       d.Get("kind").(string),
+		namespace:  d.Get("namespace").(string),
+		name:       d.Get("name").(string),
+	}
+	args := []string{"get", "-o", "json", obj.resource()}
+	if obj.namespace != "" {
+		args = append(args, "-n", obj.namespace)
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Metadata struct {
+			Namespace   string            `json:"namespace"`
+			UID         string            `json:"uid"`
+			Labels      map[string]string `json:"labels"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+
+	// Only string values are exported, other kinds may use data differently.
+	values := map[string]string{}
+	for key, value := range data.Data {
+		if value, ok := value.(string); ok {
+			values[key] = value
+		}
+	}
+
+	// The namespace defaults to the one of the kubeconfig context.
+	obj.namespace = data.Metadata.Namespace
+	d.SetId(obj.id())
+
+	for key, value := range map[string]interface{}{
+		"manifest":    stdout.String(),
+		"uid":         data.Metadata.UID,
+		"labels":      data.Metadata.Labels,
+		"annotations": data.Metadata.Annotations,
+		"data":        values,
+	} {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceResources() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceResourcesRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"api_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"kind": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"selector": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"item_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"items": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"namespace": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"manifest": &schema.Schema{
+							Type:      schema.TypeString,
+							Computed:  true,
+							Sensitive: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceResourcesRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	obj := manifestObject{
+		apiVersion: d.Get("api_version").(string),
+		kind:       d.Get("kind").(string),
+		namespace:  d.Get("namespace").(string),
+	}
+	selector := d.Get("selector").(string)
+	args := []string{"get", "-o", "json", "-l", selector, obj.qualifiedKind()}
+	if obj.namespace != "" {
+		args = append(args, "-n", obj.namespace)
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+
+	items := make([]interface{}, 0, len(data.Items))
+	for _, item := range data.Items {
+		var object struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(item, &object); err != nil {
+			return fmt.Errorf("decoding response: %v", err)
+		}
+		items = append(items, map[string]interface{}{
+			"name":      object.Metadata.Name,
+			"namespace": object.Metadata.Namespace,
+			"manifest":  string(item),
+		})
+	}
+
+	d.SetId(obj.id() + ":" + selector)
+	for key, value := range map[string]interface{}{
+		"item_count": len(items),
+		"items":      items,
+	} {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
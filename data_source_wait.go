@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func dataSourceWait() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceWaitRead,
+
+		Schema: map[string]*schema.Schema{
+			"api_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"kind": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"condition": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "5m",
+				ValidateFunc: validateDuration,
+			},
+		},
+	}
+}
+
+// dataSourceWaitRead blocks until the object meets the condition, failing if it
+// doesn't within the timeout. kubectl wait fails right away for objects which
+// don't exist yet, so it is run again until they are created.
+func dataSourceWaitRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	obj := manifestObject{
+		apiVersion: d.Get("api_version").(string),
+		kind:       d.Get("kind").(string),
+		namespace:  d.Get("namespace").(string),
+		name:       d.Get("name").(string),
+	}
+	condition := d.Get("condition").(string)
+	// Validated by the schema.
+	timeout, _ := time.ParseDuration(d.Get("timeout").(string))
+
+	deadline := time.Now().Add(timeout)
+	err = resource.Retry(timeout, func() *resource.RetryError {
+		// A negative --timeout makes kubectl wait forever.
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining < time.Second {
+			remaining = time.Second
+		}
+		args := []string{"wait", "--for=condition=" + condition, "--timeout=" + remaining.String(), obj.resource()}
+		if obj.namespace != "" {
+			args = append(args, "-n", obj.namespace)
+		}
+		if err := run(kubectl(m, kubeconfig, args...)); err != nil {
+			if strings.Contains(errorOutput(err), "(NotFound)") {
+				return resource.RetryableError(err)
+			}
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s to be %s: %v", obj.resource(), condition, err)
+	}
+
+	d.SetId(obj.id())
+	return nil
+}
@@ -2,4 +2,7 @@ module github.com/banzaicloud/terraform-provider-k8s
 
 go 1.13
 
-require github.com/hashicorp/terraform-plugin-sdk v1.4.0
+require (
+	github.com/hashicorp/terraform-plugin-sdk v1.4.0
+	sigs.k8s.io/yaml v1.2.0
+)
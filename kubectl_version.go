@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kubectlReleaseURL is where the kubectl binaries of Kubernetes releases are
+// published, formatted with the version, OS and architecture.
+const kubectlReleaseURL = "https://dl.k8s.io/release/%s/bin/%s/%s/%s"
+
+// kubectlDownloadTimeout bounds the download of a kubectl release, which is
+// made while configuring the provider, outside of any operation timeout.
+const kubectlDownloadTimeout = 10 * time.Minute
+
+// kubectlVersion is the minor version of a kubectl release.
+type kubectlVersion struct {
+	major, minor int
+}
+
+var (
+	// minKubectlVersion is the oldest kubectl release the provider works
+	// with.
+	minKubectlVersion = kubectlVersion{1, 12}
+	// serverSideKubectlVersion is the first kubectl release supporting
+	// --server-side and --dry-run=server.
+	serverSideKubectlVersion = kubectlVersion{1, 18}
+	// kustomizeKubectlVersion is the first kubectl release including
+	// kustomize.
+	kustomizeKubectlVersion = kubectlVersion{1, 14}
+	// diffKubectlVersion is the first kubectl release including kubectl
+	// diff.
+	diffKubectlVersion = kubectlVersion{1, 13}
+	// cascadePolicyKubectlVersion is the first kubectl release accepting
+	// --cascade=background|foreground|orphan.
+	cascadePolicyKubectlVersion = kubectlVersion{1, 20}
+)
+
+var kubectlVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// parseKubectlVersion parses versions such as v1.18.2 or v1.16.8-eks-e16311.
+func parseKubectlVersion(s string) (kubectlVersion, error) {
+	match := kubectlVersionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return kubectlVersion{}, fmt.Errorf("could not parse kubectl version %q", s)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return kubectlVersion{major, minor}, nil
+}
+
+func (v kubectlVersion) atLeast(o kubectlVersion) bool {
+	return v.major > o.major || v.major == o.major && v.minor >= o.minor
+}
+
+func (v kubectlVersion) String() string {
+	return fmt.Sprintf("v%d.%d", v.major, v.minor)
+}
+
+// requireKubectl returns an error if the configured kubectl is older than
+// version, which is required by feature.
+func (c *config) requireKubectl(version kubectlVersion, feature string) error {
+	if !c.kubectlVersion.atLeast(version) {
+		return fmt.Errorf("kubectl %s or newer is required by %s, found %s", version, feature, c.kubectlVersion)
+	}
+	return nil
+}
+
+// clientDryRunArg returns the flag requesting a client-side dry run. kubectl
+// releases before --dry-run=server only know it as a plain --dry-run.
+func (c *config) clientDryRunArg() string {
+	if c.kubectlVersion.atLeast(serverSideKubectlVersion) {
+		return "--dry-run=client"
+	}
+	return "--dry-run"
+}
+
+// kubectlClientVersion returns the version of the kubectl binary at path, e.g.
+// v1.18.2.
+func kubectlClientVersion(path string) (string, error) {
+	stdout := &bytes.Buffer{}
+	cmd := exec.Command(path, "version", "--client", "-o", "json")
+	cmd.Stdout = stdout
+	if err := run(cmd); err != nil {
+		return "", err
+	}
+
+	var data struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return "", fmt.Errorf("decoding kubectl version: %v", err)
+	}
+	if data.ClientVersion.GitVersion == "" {
+		return "", fmt.Errorf("could not parse kubectl version from %s", stdout.String())
+	}
+	return data.ClientVersion.GitVersion, nil
+}
+
+// pinnedKubectl returns the path of a kubectl binary of the given version. The
+// binary at path is used if it has the right version, otherwise the release is
+// downloaded into the user's cache directory once and reused from there. The
+// download is verified against the published SHA-256 checksum, which is cached
+// along with the binary so that the cached binary is verified before every use.
+func pinnedKubectl(path, version string) (string, error) {
+	version = "v" + strings.TrimPrefix(version, "v")
+
+	if found, err := exec.LookPath(path); err == nil {
+		if v, err := kubectlClientVersion(found); err == nil && v == version {
+			return found, nil
+		}
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("determining cache directory: %v", err)
+	}
+	binary := "kubectl"
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	dir := filepath.Join(cacheDir, "terraform-provider-k8s", "kubectl", version)
+	cached := filepath.Join(dir, binary)
+	checksumFile := cached + ".sha256"
+	if checksum, err := ioutil.ReadFile(checksumFile); err == nil {
+		if err := verifyChecksum(cached, string(checksum)); err == nil {
+			return cached, nil
+		} else if !os.IsNotExist(err) {
+			log.Printf("[WARN] downloading kubectl %s again: %v", version, err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating cache directory: %v", err)
+	}
+	url := fmt.Sprintf(kubectlReleaseURL, version, runtime.GOOS, runtime.GOARCH, binary)
+	checksum, err := fetchChecksum(url + ".sha256")
+	if err != nil {
+		return "", fmt.Errorf("fetching the checksum of kubectl %s: %v", version, err)
+	}
+	if err := download(url, cached, checksum); err != nil {
+		return "", fmt.Errorf("downloading kubectl %s: %v", version, err)
+	}
+	if err := ioutil.WriteFile(checksumFile, []byte(checksum), 0644); err != nil {
+		return "", fmt.Errorf("caching the checksum of kubectl %s: %v", version, err)
+	}
+	return cached, nil
+}
+
+// fetchChecksum returns the hex-encoded SHA-256 checksum published at url. The
+// file may list the name of the checksummed file after the checksum.
+func fetchChecksum(url string) (string, error) {
+	resp, err := httpGet(url, defaultReadTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no checksum found at %s", url)
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil || len(fields[0]) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid checksum %q at %s", fields[0], url)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// verifyChecksum returns an error unless the file at path has the given
+// hex-encoded SHA-256 checksum.
+func verifyChecksum(path, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != strings.TrimSpace(checksum) {
+		return fmt.Errorf("checksum of %s is %s, expected %s", path, actual, strings.TrimSpace(checksum))
+	}
+	return nil
+}
+
+// download stores the file at url as an executable at path, if it has the given
+// SHA-256 checksum. The file is written next to path first, verified and
+// renamed, so that an interrupted or corrupted download doesn't leave a broken
+// binary behind.
+func download(url, path, checksum string) error {
+	resp, err := httpGet(url, kubectlDownloadTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+"_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := io.Copy(tmpfile, resp.Body); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+	if err := verifyChecksum(tmpfile.Name(), checksum); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpfile.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile.Name(), path)
+}
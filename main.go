@@ -2,18 +2,34 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 	"github.com/hashicorp/terraform-plugin-sdk/plugin"
 	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"sigs.k8s.io/yaml"
 )
 
 type config struct {
@@ -22,9 +38,77 @@ type config struct {
 	kubeconfigContext string
 	kubectlPath       string
 	kubectlToken      string
+	kubectlTokenFile  string
+	fieldManager      string
+	namespace         string
+	managedBy         string
+	validate          bool
+	checkPermissions  bool
+	inCluster         bool
+	impersonateUser   string
+	impersonateGroups []string
+	extraArgs         []string
+	tempDir           string
+	requestTimeout    string
+	insecure          bool
+	host              string
+	httpProxy         string
+	httpsProxy        string
+	noProxy           string
+	kubectlVersion    kubectlVersion
+	retryAttempts     int
+	retryInterval     time.Duration
+
+	clientCertificate    string
+	clientKey            string
+	clusterCACertificate string
+
+	exec *execConfig
+}
+
+// execConfig configures an exec-based credential plugin.
+type execConfig struct {
+	apiVersion string
+	command    string
+	args       []string
+	env        map[string]string
 }
 
+// execUser is the name of the kubeconfig user authenticating with the exec
+// credential plugin configured on the provider.
+const execUser = "terraform-provider-k8s-exec"
+
+// defaultFieldManager is the field manager recorded for server-side applies
+// when the provider doesn't configure one.
+const defaultFieldManager = "terraform-provider-k8s"
+
+// defaultReadTimeout bounds how long reads retry, so that a refresh fails fast
+// when the cluster is unreachable.
+const defaultReadTimeout = 2 * time.Minute
+
+// managedByAnnotation marks the objects applied by the provider when
+// managed_by is configured.
+const managedByAnnotation = "terraform.io/managed-by"
+
+// changeCauseAnnotation is the annotation kubectl rollout history shows as the
+// cause of a revision.
+const changeCauseAnnotation = "kubernetes.io/change-cause"
+
 func main() {
+	rand.Seed(time.Now().UnixNano())
+
+	// Terraform lets running operations finish on the first interrupt, which
+	// clean up their temporary files themselves. The files left behind when the
+	// provider is stopped or terminated instead are removed here.
+	defer removeTempFiles()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		<-signals
+		removeTempFiles()
+		os.Exit(1)
+	}()
+
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: func() terraform.ResourceProvider {
 			return &schema.Provider{
@@ -46,296 +130,3068 @@ func main() {
 						Optional: true,
 					},
 					"kubectl_token": &schema.Schema{
+						Type:          schema.TypeString,
+						Optional:      true,
+						ConflictsWith: []string{"kubectl_token_file"},
+					},
+					"kubectl_token_file": &schema.Schema{
+						Type:          schema.TypeString,
+						Optional:      true,
+						ConflictsWith: []string{"kubectl_token"},
+					},
+					"kubectl_version": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"field_manager": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"namespace": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"managed_by": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"in_cluster": &schema.Schema{
+						Type:          schema.TypeBool,
+						Optional:      true,
+						Default:       false,
+						ConflictsWith: []string{"kubeconfig", "kubeconfig_content"},
+					},
+					"impersonate_user": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"impersonate_groups": &schema.Schema{
+						Type:     schema.TypeList,
+						Optional: true,
+						Elem:     &schema.Schema{Type: schema.TypeString},
+					},
+					"extra_args": &schema.Schema{
+						Type:     schema.TypeList,
+						Optional: true,
+						Elem:     &schema.Schema{Type: schema.TypeString},
+					},
+					"temp_dir": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"retry_attempts": &schema.Schema{
+						Type:         schema.TypeInt,
+						Optional:     true,
+						ValidateFunc: validation.IntAtLeast(0),
+					},
+					"retry_interval": &schema.Schema{
+						Type:         schema.TypeString,
+						Optional:     true,
+						ValidateFunc: validateDuration,
+					},
+					"client_certificate": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"client_key": &schema.Schema{
+						Type:      schema.TypeString,
+						Optional:  true,
+						Sensitive: true,
+					},
+					"cluster_ca_certificate": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"exec": &schema.Schema{
+						Type:     schema.TypeList,
+						Optional: true,
+						MaxItems: 1,
+						Elem: &schema.Resource{
+							Schema: map[string]*schema.Schema{
+								"api_version": &schema.Schema{
+									Type:     schema.TypeString,
+									Required: true,
+								},
+								"command": &schema.Schema{
+									Type:     schema.TypeString,
+									Required: true,
+								},
+								"env": &schema.Schema{
+									Type:     schema.TypeMap,
+									Optional: true,
+									Elem:     &schema.Schema{Type: schema.TypeString},
+								},
+								"args": &schema.Schema{
+									Type:     schema.TypeList,
+									Optional: true,
+									Elem:     &schema.Schema{Type: schema.TypeString},
+								},
+							},
+						},
+					},
+					"request_timeout": &schema.Schema{
+						Type:         schema.TypeString,
+						Optional:     true,
+						ValidateFunc: validateRequestTimeout,
+					},
+					"host": &schema.Schema{
+						Type:          schema.TypeString,
+						Optional:      true,
+						ConflictsWith: []string{"in_cluster"},
+					},
+					"http_proxy": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"https_proxy": &schema.Schema{
 						Type:     schema.TypeString,
 						Optional: true,
 					},
+					"no_proxy": &schema.Schema{
+						Type:     schema.TypeString,
+						Optional: true,
+					},
+					"insecure_skip_tls_verify": &schema.Schema{
+						Type:          schema.TypeBool,
+						Optional:      true,
+						ConflictsWith: []string{"cluster_ca_certificate", "in_cluster"},
+					},
+					"kubectl_validate": &schema.Schema{
+						Type:     schema.TypeBool,
+						Optional: true,
+						Default:  true,
+					},
+					"check_connection": &schema.Schema{
+						Type:     schema.TypeBool,
+						Optional: true,
+						Default:  false,
+					},
+					"check_permissions": &schema.Schema{
+						Type:     schema.TypeBool,
+						Optional: true,
+						Default:  false,
+					},
 				},
 				ResourcesMap: map[string]*schema.Resource{
-					"k8s_manifest": resourceManifest(),
+					"k8s_manifest":      resourceManifest(),
+					"k8s_manifest_list": resourceManifestList(),
 				},
-				ConfigureFunc: func(d *schema.ResourceData) (interface{}, error) {
-					return &config{
-						kubeconfig:        d.Get("kubeconfig").(string),
-						kubeconfigContent: d.Get("kubeconfig_content").(string),
-						kubeconfigContext: d.Get("kubeconfig_context").(string),
-						kubectlPath:       d.Get("kubectl_path").(string),
-						kubectlToken:      d.Get("kubectl_token").(string),
-					}, nil
+				DataSourcesMap: map[string]*schema.Resource{
+					"k8s_resource":            dataSourceResource(),
+					"k8s_manifest_validation": dataSourceManifestValidation(),
+					"k8s_pod_logs":            dataSourcePodLogs(),
+					"k8s_exec":                dataSourceExec(),
+					"k8s_field":               dataSourceField(),
+					"k8s_wait":                dataSourceWait(),
+					"k8s_resources":           dataSourceResources(),
+					"k8s_cluster_info":        dataSourceClusterInfo(),
 				},
+				ConfigureFunc: providerConfigure,
 			}
 		},
 	})
 }
 
+// validateRequestTimeout accepts the values understood by kubectl's
+// --request-timeout flag: a duration such as 30s or a number of seconds.
+func validateRequestTimeout(v interface{}, k string) ([]string, []error) {
+	value := v.(string)
+	if _, err := strconv.Atoi(value); err == nil {
+		return nil, nil
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		return nil, []error{fmt.Errorf("%s must be a duration (e.g. 30s) or a number of seconds, got %q", k, value)}
+	}
+	return nil, nil
+}
+
+// validateDuration accepts durations such as 5m or 30s.
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s must be a duration (e.g. 5m): %v", k, err)}
+	}
+	return nil, nil
+}
+
+// validateManifest accepts manifests whose documents parse as YAML or JSON, so
+// that syntax errors are reported before kubectl is run.
+func validateManifest(v interface{}, k string) ([]string, []error) {
+	if _, err := decodeManifest(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s is not a valid manifest: %v", k, err)}
+	}
+	return nil, nil
+}
+
+// validateBase64 accepts base64 encoded strings.
+func validateBase64(v interface{}, k string) ([]string, []error) {
+	if _, err := base64.StdEncoding.DecodeString(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s must be base64 encoded: %v", k, err)}
+	}
+	return nil, nil
+}
+
+// validateURL accepts http and https URLs.
+func validateURL(v interface{}, k string) ([]string, []error) {
+	u, err := url.Parse(v.(string))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, []error{fmt.Errorf("%s must be an http or https URL, got %q", k, v)}
+	}
+	return nil, nil
+}
+
+func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+	c := &config{
+		kubeconfig:        d.Get("kubeconfig").(string),
+		kubeconfigContent: d.Get("kubeconfig_content").(string),
+		kubeconfigContext: d.Get("kubeconfig_context").(string),
+		kubectlPath:       d.Get("kubectl_path").(string),
+		kubectlToken:      d.Get("kubectl_token").(string),
+		kubectlTokenFile:  d.Get("kubectl_token_file").(string),
+		fieldManager:      d.Get("field_manager").(string),
+		tempDir:           d.Get("temp_dir").(string),
+		namespace:         d.Get("namespace").(string),
+		managedBy:         d.Get("managed_by").(string),
+		validate:          d.Get("kubectl_validate").(bool),
+		checkPermissions:  d.Get("check_permissions").(bool),
+		inCluster:         d.Get("in_cluster").(bool),
+		impersonateUser:   d.Get("impersonate_user").(string),
+		requestTimeout:    d.Get("request_timeout").(string),
+		insecure:          d.Get("insecure_skip_tls_verify").(bool),
+		host:              d.Get("host").(string),
+		httpProxy:         d.Get("http_proxy").(string),
+		httpsProxy:        d.Get("https_proxy").(string),
+		noProxy:           d.Get("no_proxy").(string),
+		retryAttempts:     d.Get("retry_attempts").(int),
+
+		clientCertificate:    d.Get("client_certificate").(string),
+		clientKey:            d.Get("client_key").(string),
+		clusterCACertificate: d.Get("cluster_ca_certificate").(string),
+	}
+	for _, group := range d.Get("impersonate_groups").([]interface{}) {
+		c.impersonateGroups = append(c.impersonateGroups, group.(string))
+	}
+	for _, arg := range d.Get("extra_args").([]interface{}) {
+		c.extraArgs = append(c.extraArgs, arg.(string))
+	}
+	if interval := d.Get("retry_interval").(string); interval != "" {
+		// Validated by the schema.
+		c.retryInterval, _ = time.ParseDuration(interval)
+	}
+
+	if v := d.Get("exec").([]interface{}); len(v) > 0 && v[0] != nil {
+		block := v[0].(map[string]interface{})
+		c.exec = &execConfig{
+			apiVersion: block["api_version"].(string),
+			command:    block["command"].(string),
+			env:        map[string]string{},
+		}
+		for _, arg := range block["args"].([]interface{}) {
+			c.exec.args = append(c.exec.args, arg.(string))
+		}
+		for name, value := range block["env"].(map[string]interface{}) {
+			c.exec.env[name] = value.(string)
+		}
+	}
+
+	if (c.clientCertificate == "") != (c.clientKey == "") {
+		return nil, fmt.Errorf("client_certificate and client_key have to be set together")
+	}
+	if c.kubectlTokenFile != "" {
+		if _, err := ioutil.ReadFile(c.kubectlTokenFile); err != nil {
+			return nil, fmt.Errorf("reading kubectl_token_file: %v", err)
+		}
+	}
+
+	if version := d.Get("kubectl_version").(string); version != "" {
+		path := c.kubectlPath
+		if path == "" {
+			path = "kubectl"
+		}
+		path, err := pinnedKubectl(path, version)
+		if err != nil {
+			return nil, err
+		}
+		c.kubectlPath = path
+	}
+
+	path := c.kubectlPath
+	if path == "" {
+		path = "kubectl"
+	}
+	version, err := kubectlClientVersion(path)
+	if err != nil {
+		return nil, fmt.Errorf("determining kubectl version: %v", err)
+	}
+	if c.kubectlVersion, err = parseKubectlVersion(version); err != nil {
+		return nil, err
+	}
+	if err := c.requireKubectl(minKubectlVersion, "the provider"); err != nil {
+		return nil, err
+	}
+
+	if c.inCluster && os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return nil, fmt.Errorf("in_cluster is set but KUBERNETES_SERVICE_HOST is not defined, " +
+			"the provider doesn't seem to be running in a pod")
+	}
+
+	if d.Get("check_connection").(bool) {
+		if err := checkConnection(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// connectionCheckTimeout bounds the request of checkConnection unless the
+// provider configures a request_timeout.
+const connectionCheckTimeout = "10s"
+
+// checkConnection verifies that the API server of the cluster can be reached,
+// so that an unreachable cluster fails fast instead of after the retries of the
+// first operation.
+func checkConnection(c *config) error {
+	kubeconfig, cleanup, err := kubeconfigPath(c)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	host := c.host
+	if host == "" {
+		stdout := &bytes.Buffer{}
+		cmd := kubectl(c, kubeconfig, "config", "view", "--minify", "-o", "jsonpath={.clusters[0].cluster.server}")
+		cmd.Stdout = stdout
+		if err := run(cmd); err == nil {
+			host = strings.TrimSpace(stdout.String())
+		}
+	}
+	if host == "" {
+		host = "the configured server"
+	}
+
+	args := []string{"version", "-o", "json"}
+	if c.requestTimeout == "" {
+		args = append(args, "--request-timeout", connectionCheckTimeout)
+	}
+	if err := run(kubectl(c, kubeconfig, args...)); err != nil {
+		return fmt.Errorf("cannot connect to cluster at %s: %v", host, err)
+	}
+	return nil
+}
+
 func resourceManifest() *schema.Resource {
 	return &schema.Resource{
 		Create: resourceManifestCreate,
 		Read:   resourceManifestRead,
 		Update: resourceManifestUpdate,
 		Delete: resourceManifestDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceManifestImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Update: schema.DefaultTimeout(20 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+			Read:   schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		CustomizeDiff: resourceManifestCustomizeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"namespace": &schema.Schema{
-				Type:      schema.TypeString,
-				Optional:  true,
-				Sensitive: false,
-				ForceNew:  true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     false,
+				ForceNew:      true,
+				ConflictsWith: []string{"namespaces"},
+			},
+			"namespaces": &schema.Schema{
+				Type:          schema.TypeList,
+				Optional:      true,
+				ForceNew:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"namespace"},
+			},
+			"context": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"kubeconfig": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
 			},
 			"content": &schema.Schema{
-				Type:      schema.TypeString,
-				Required:  true,
-				Sensitive: false,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        false,
+				ExactlyOneOf:     []string{"content", "content_base64", "object", "kustomize_directory", "url"},
+				ValidateFunc:     validateManifest,
+				DiffSuppressFunc: suppressEquivalentManifest,
+			},
+			"content_base64": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ExactlyOneOf:     []string{"content", "content_base64", "object", "kustomize_directory", "url"},
+				ValidateFunc:     validateBase64,
+				DiffSuppressFunc: suppressEquivalentBase64Manifest,
+			},
+			"object": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateFunc:     validation.ValidateJsonString,
+				ExactlyOneOf:     []string{"content", "content_base64", "object", "kustomize_directory", "url"},
+				DiffSuppressFunc: suppressEquivalentManifest,
+			},
+			"kustomize_directory": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "content_base64", "object", "kustomize_directory", "url"},
+			},
+			"url": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "content_base64", "object", "kustomize_directory", "url"},
+				ValidateFunc: validateURL,
 			},
 			"validate": &schema.Schema{
 				Type:     schema.TypeBool,
 				Optional: true,
+			},
+			"server_side_apply": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"force_conflicts": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"overwrite": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
 				Default:  true,
 			},
+			"server_dry_run": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"prune": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"prune_selector": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"atomic": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"sort_by_kind": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"image_pull_secrets": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"vars": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"change_cause": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"create_namespace": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"apply_method": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "apply",
+				ValidateFunc: validation.StringInSlice([]string{"apply", "create", "replace"}, false),
+			},
+			"optimistic_lock": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"recreate_on_conflict": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"wait_for": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"condition": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"jsonpath": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"value": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"timeout": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDuration,
+						},
+					},
+				},
+			},
+			"wait_for_rollout": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"ignore_fields": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"extra_args": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"wait_for_delete": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"delete_wait_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDuration,
+			},
+			"delete_grace_period": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  -1,
+			},
+			"delete_force": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"delete_cascade": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"background", "foreground", "orphan"}, false),
+			},
+			"delete_selector": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"check_delete_permissions": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"api_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"kind": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"uid": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"status": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"content_hash": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"resource_versions": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"generations": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"plan": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"objects": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"api_version": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"kind": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"namespace": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"last_applied_content": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"last_apply_result": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
 		},
 	}
 }
 
-func run(cmd *exec.Cmd) error {
-	stderr := &bytes.Buffer{}
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		cmdStr := cmd.Path + " " + strings.Join(cmd.Args, " ")
-		if stderr.Len() == 0 {
-			return fmt.Errorf("%s: %v", cmdStr, err)
-		}
-		return fmt.Errorf("%s %v: %s", cmdStr, err, stderr.Bytes())
+// run runs cmd and includes its stderr in the returned error. Unless the caller
+// captures the output of cmd, e.g. to decode objects which may include secrets,
+// it is logged at the DEBUG level so that TF_LOG=DEBUG shows what kubectl did.
+func run(cmd *exec.Cmd) error {
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	var stdout *bytes.Buffer
+	if cmd.Stdout == nil {
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		defer func() {
+			if stdout.Len() > 0 {
+				log.Printf("[DEBUG] kubectl output:\n%s", stdout.Bytes())
+			}
+		}()
+	}
+	if err := cmd.Run(); err != nil {
+		return &commandError{command: commandString(cmd), err: err, stderr: stderr.String()}
+	}
+	return nil
+}
+
+// commandError is the error of a failed command. Its stderr is kept apart from
+// the command line, so that errors are classified by what the command reported
+// rather than by its arguments.
+type commandError struct {
+	command string
+	err     error
+	stderr  string
+}
+
+func (e *commandError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("%s: %v", e.command, e.err)
+	}
+	return fmt.Sprintf("%s %v: %s", e.command, e.err, e.stderr)
+}
+
+// errorOutput returns what a failed command reported on stderr, or the message
+// of err if it isn't the error of a command.
+func errorOutput(err error) string {
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) {
+		return err.Error()
+	}
+	if cmdErr.stderr == "" {
+		return cmdErr.err.Error()
+	}
+	return cmdErr.stderr
+}
+
+// runAllowingExitCodes runs cmd like run, but doesn't fail when cmd exits with
+// one of the allowed codes, for commands such as kubectl diff which report a
+// result through their exit code. It returns the stdout and the exit code of
+// cmd.
+func runAllowingExitCodes(cmd *exec.Cmd, allowed ...int) (string, int, error) {
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	err := run(cmd)
+	code := 0
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		for _, c := range allowed {
+			if code == c {
+				return stdout.String(), code, nil
+			}
+		}
+	}
+	return stdout.String(), code, err
+}
+
+// getObject gets the object k8sResource in the given output format. found is
+// only false when kubectl fails with a NotFound error. The empty output of
+// kubectl get --ignore-not-found can also be caused by failures kubectl doesn't
+// report, which would make the object look deleted, so a successful get without
+// output is an error instead.
+func getObject(m interface{}, kubeconfig kubeconfigFiles, k8sResource, namespace, output string, timeout time.Duration) (*bytes.Buffer, bool, error) {
+	args := []string{"get", "-o", output, k8sResource}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+
+	var stdout *bytes.Buffer
+	found := true
+	err := retry(m, timeout, func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout = &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			if strings.Contains(errorOutput(err), "(NotFound)") {
+				found = false
+				return nil
+			}
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if found && strings.TrimSpace(stdout.String()) == "" {
+		return nil, false, fmt.Errorf("getting %s: kubectl returned no output", k8sResource)
+	}
+	return stdout, found, nil
+}
+
+// sensitiveFlags are the kubectl flags whose values are redacted from error
+// messages.
+var sensitiveFlags = []string{
+	"--token",
+	"--client-key",
+	"--password",
+	"--username",
+}
+
+// commandString formats cmd for error messages. cmd.Args already starts with
+// the program, the values of sensitiveFlags are redacted.
+func commandString(cmd *exec.Cmd) string {
+	args := make([]string, len(cmd.Args))
+	copy(args, cmd.Args)
+	for i, arg := range args {
+		for _, flag := range sensitiveFlags {
+			if arg == flag && i+1 < len(args) {
+				args[i+1] = "<redacted>"
+			} else if strings.HasPrefix(arg, flag+"=") {
+				args[i] = flag + "=<redacted>"
+			}
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// transientErrors are parts of kubectl error messages of failures which may not
+// happen again when retried. They are specific phrases, since words such as
+// "timeout" or "conflict" also show up in permanent errors, e.g. about the
+// timeoutSeconds field of a manifest.
+var transientErrors = []string{
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+	"context deadline exceeded",
+	"the object has been modified",
+}
+
+// validationErrors are parts of kubectl error messages of manifests which are
+// invalid.
+var validationErrors = []string{
+	"error converting yaml",
+	"error parsing",
+	"error validating",
+	"is invalid",
+	"unknown field",
+	"cannot be handled as",
+}
+
+// permanentErrors are parts of kubectl error messages of failures caused by
+// the manifest or the permissions of the user, which would happen again.
+var permanentErrors = append([]string{
+	"forbidden",
+	// Conflicts with other field managers of a server-side apply.
+	"apply failed with",
+}, validationErrors...)
+
+// isValidationError reports whether a kubectl command failed because the
+// manifest is invalid, rather than e.g. because the API server validating it
+// couldn't be reached.
+func isValidationError(err error) bool {
+	message := strings.ToLower(errorOutput(err))
+	for _, transient := range transientErrors {
+		if strings.Contains(message, transient) {
+			return false
+		}
+	}
+	for _, validation := range validationErrors {
+		if strings.Contains(message, validation) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverTimeoutErrors are parts of kubectl error messages of requests the API
+// server or etcd failed to complete in time, e.g. on a busy cluster. They are
+// always retried, after a short backoff.
+var serverTimeoutErrors = []string{
+	"etcdserver: request timed out",
+	"etcdserver: leader changed",
+	"the server was unable to return a response in the time allotted",
+	"request did not complete within requested timeout",
+}
+
+// serverTimeoutAttempts is how many times a command failing with one of the
+// serverTimeoutErrors is run before the failure is handled like any other,
+// waiting serverTimeoutBackoff times the number of attempts in between.
+const (
+	serverTimeoutAttempts = 3
+	serverTimeoutBackoff  = time.Second
+)
+
+// isServerTimeout reports whether err is one of the serverTimeoutErrors.
+func isServerTimeout(err error) bool {
+	message := strings.ToLower(errorOutput(err))
+	for _, timeout := range serverTimeoutErrors {
+		if strings.Contains(message, timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryError wraps an error of a kubectl command for resource.Retry, classified
+// by the command's stderr. Permanent failures, such as an invalid manifest, are
+// checked first and not retried, transient and unknown errors are.
+func retryError(err error) *resource.RetryError {
+	if isServerTimeout(err) {
+		return resource.RetryableError(err)
+	}
+	message := strings.ToLower(errorOutput(err))
+	for _, permanent := range permanentErrors {
+		if strings.Contains(message, permanent) {
+			return resource.NonRetryableError(err)
+		}
+	}
+	for _, transient := range transientErrors {
+		if strings.Contains(message, transient) {
+			return resource.RetryableError(err)
+		}
+	}
+	return resource.RetryableError(err)
+}
+
+// retry calls f until it succeeds, returns a non-retryable error or the timeout
+// expires, like resource.Retry. When the provider configures retry_attempts or
+// retry_interval, f is called at most that many times, waiting the interval
+// between the attempts. Otherwise the wait grows exponentially with jitter, so
+// that resources failing at the same time don't retry in lockstep.
+func retry(m interface{}, timeout time.Duration, f resource.RetryFunc) error {
+	f = retryServerTimeouts(f)
+	c := m.(*config)
+
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		rerr := f()
+		if rerr == nil {
+			return nil
+		}
+		if !rerr.Retryable {
+			return rerr.Err
+		}
+		if c.retryAttempts > 0 && attempt >= c.retryAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, rerr.Err)
+		}
+		interval := c.retryInterval
+		if interval == 0 {
+			interval = retryBackoff(attempt)
+		}
+		if delay, ok := throttleDelay(rerr.Err); ok && delay > interval {
+			interval = delay
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timeout after %d attempts: %w", attempt, rerr.Err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// throttledErrors are parts of kubectl error messages of requests rejected by
+// the API server with 429 Too Many Requests.
+var throttledErrors = []string{
+	"toomanyrequests",
+	"too many requests",
+}
+
+var retryAfterPattern = regexp.MustCompile(`(?i)retry.after\D{0,3}(\d+)`)
+
+// defaultThrottleDelay is how long to wait after a throttled request when the
+// error doesn't tell.
+const defaultThrottleDelay = 5 * time.Second
+
+// throttleDelay reports whether err is a throttled request and how long to wait
+// before trying again: the Retry-After seconds if the message includes them,
+// defaultThrottleDelay otherwise.
+func throttleDelay(err error) (time.Duration, bool) {
+	message := strings.ToLower(errorOutput(err))
+	for _, throttled := range throttledErrors {
+		if !strings.Contains(message, throttled) {
+			continue
+		}
+		if match := retryAfterPattern.FindStringSubmatch(message); match != nil {
+			seconds, _ := strconv.Atoi(match[1])
+			return time.Duration(seconds) * time.Second, true
+		}
+		return defaultThrottleDelay, true
+	}
+	return 0, false
+}
+
+// The wait after a failed attempt starts at minRetryBackoff and doubles with
+// every attempt up to maxRetryBackoff.
+const (
+	minRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff = 10 * time.Second
+)
+
+// retryBackoff returns how long to wait after the given failed attempt: half of
+// the exponential backoff plus a random part of the other half.
+func retryBackoff(attempt int) time.Duration {
+	backoff := minRetryBackoff
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// retryServerTimeouts wraps f so that server timeouts are retried right away
+// with a short backoff instead of the growing interval of other failures.
+func retryServerTimeouts(f resource.RetryFunc) resource.RetryFunc {
+	return func() *resource.RetryError {
+		for attempt := 1; ; attempt++ {
+			rerr := f()
+			if rerr == nil || attempt >= serverTimeoutAttempts || !isServerTimeout(rerr.Err) {
+				return rerr
+			}
+			log.Printf("[DEBUG] Retrying server timeout: %v", rerr.Err)
+			time.Sleep(time.Duration(attempt) * serverTimeoutBackoff)
+		}
+	}
+}
+
+// kubeconfigFiles are the files kubectl reads its configuration and
+// credentials from.
+type kubeconfigFiles struct {
+	kubeconfig           string
+	user                 string
+	clientCertificate    string
+	clientKey            string
+	certificateAuthority string
+	token                string
+}
+
+// kubeconfigPath returns the files kubectl reads its configuration and
+// credentials from. Content configured on the provider is written to temporary
+// files, which are removed by the returned cleanup function.
+func kubeconfigPath(m interface{}) (kubeconfigFiles, func(), error) {
+	c := m.(*config)
+	files := kubeconfigFiles{kubeconfig: c.kubeconfig}
+	if c.kubeconfig == "" && c.kubeconfigContent == "" && !c.inCluster {
+		files.kubeconfig = defaultKubeconfig()
+	}
+	if c.kubectlTokenFile != "" {
+		// The token is read for every operation as it may be rotated on
+		// disk. Falling back to other credentials could act as another user,
+		// so an unreadable file fails the operation.
+		data, err := ioutil.ReadFile(c.kubectlTokenFile)
+		if err != nil {
+			return kubeconfigFiles{}, func() {}, fmt.Errorf("reading kubectl_token_file: %v", err)
+		}
+		files.token = strings.TrimSpace(string(data))
+	}
+	var cleanups []func()
+	var cleanupFunc = func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}
+
+	if c.kubeconfig != "" && c.kubeconfigContent != "" {
+		return files, cleanupFunc, fmt.Errorf("both kubeconfig and kubeconfig_content are defined, " +
+			"please use only one of the paramters")
+	}
+
+	kubeconfigContent := c.kubeconfigContent
+	if c.exec != nil {
+		var err error
+		if kubeconfigContent, err = execKubeconfig(c, files.kubeconfig); err != nil {
+			return files, cleanupFunc, err
+		}
+		files.user = execUser
+	}
+
+	for _, file := range []struct {
+		name    string
+		content string
+		path    *string
+	}{
+		{"kubeconfig", kubeconfigContent, &files.kubeconfig},
+		{"client_certificate", c.clientCertificate, &files.clientCertificate},
+		{"client_key", c.clientKey, &files.clientKey},
+		{"cluster_ca_certificate", c.clusterCACertificate, &files.certificateAuthority},
+	} {
+		if file.content == "" {
+			continue
+		}
+		path, cleanup, err := writeTempFile(c.tempDir, file.name, file.content)
+		if err != nil {
+			defer cleanupFunc()
+			return kubeconfigFiles{}, cleanupFunc, err
+		}
+		cleanups = append(cleanups, cleanup)
+		*file.path = path
+	}
+
+	return files, cleanupFunc, nil
+}
+
+// defaultKubeconfig returns the kubeconfig kubectl uses when none is
+// configured: the existing files listed in the KUBECONFIG environment variable,
+// or ~/.kube/config if it exists. kubectl ignores missing files of KUBECONFIG
+// but fails for a missing --kubeconfig, so an empty string is returned if none
+// of them exist, leaving KUBECONFIG to kubectl. It is also empty if there is no
+// kubeconfig at all, e.g. when HOME isn't set.
+func defaultKubeconfig() string {
+	listed := false
+	var paths []string
+	for _, path := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if path == "" {
+			continue
+		}
+		listed = true
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if listed {
+		return strings.Join(paths, string(filepath.ListSeparator))
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".kube", "config")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// execKubeconfig returns the kubeconfig at path (or the configured content)
+// extended with a user authenticating with the exec credential plugin of the
+// provider.
+func execKubeconfig(c *config, path string) (string, error) {
+	content := c.kubeconfigContent
+	if strings.ContainsRune(path, filepath.ListSeparator) {
+		return "", fmt.Errorf("exec can't be combined with a KUBECONFIG listing multiple files")
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading kubeconfig: %v", err)
+		}
+		content = string(data)
+	}
+
+	kubeconfig := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(content), &kubeconfig); err != nil {
+		return "", fmt.Errorf("parsing kubeconfig: %v", err)
+	}
+	if kubeconfig == nil {
+		kubeconfig = map[string]interface{}{}
+	}
+	kubeconfig["apiVersion"] = "v1"
+	kubeconfig["kind"] = "Config"
+
+	env := []interface{}{}
+	for name, value := range c.exec.env {
+		env = append(env, map[string]interface{}{"name": name, "value": value})
+	}
+	users, _ := kubeconfig["users"].([]interface{})
+	kubeconfig["users"] = append(users, map[string]interface{}{
+		"name": execUser,
+		"user": map[string]interface{}{
+			"exec": map[string]interface{}{
+				"apiVersion": c.exec.apiVersion,
+				"command":    c.exec.command,
+				"args":       c.exec.args,
+				"env":        env,
+			},
+		},
+	})
+
+	data, err := yaml.Marshal(kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("encoding kubeconfig: %v", err)
+	}
+	return string(data), nil
+}
+
+// tempFiles are the temporary files of the provider which haven't been removed
+// yet.
+var tempFiles = &tempFileSet{paths: map[string]bool{}}
+
+type tempFileSet struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+func (s *tempFileSet) add(path string) {
+	s.Lock()
+	defer s.Unlock()
+	s.paths[path] = true
+}
+
+// remove removes the file at path.
+func (s *tempFileSet) remove(path string) {
+	s.Lock()
+	defer s.Unlock()
+	os.Remove(path)
+	delete(s.paths, path)
+}
+
+// removeTempFiles removes the temporary files which are left.
+func removeTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for path := range tempFiles.paths {
+		os.Remove(path)
+	}
+	tempFiles.paths = map[string]bool{}
+}
+
+// writeTempFile writes content to a new temporary file in dir, or the default
+// directory for temporary files if dir is empty, and returns its path along
+// with a function removing it. The file is created exclusively under a
+// random name, so concurrent kubectl calls, including the ones of provider
+// instances configured for different clusters, never share a file.
+func writeTempFile(dir, name, content string) (string, func(), error) {
+	tmpfile, err := ioutil.TempFile(dir, name+"_")
+	if err != nil {
+		return "", nil, fmt.Errorf("creating a %s file: %v", name, err)
+	}
+
+	tempFiles.add(tmpfile.Name())
+	cleanup := func() { tempFiles.remove(tmpfile.Name()) }
+
+	// ioutil.TempFile creates the file with mode 0600, which is enforced anyway
+	// as the file holds credentials.
+	if err = tmpfile.Chmod(0600); err != nil {
+		tmpfile.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("restricting permissions of %s file: %v", name, err)
+	}
+	if _, err = io.WriteString(tmpfile, content); err != nil {
+		tmpfile.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("writing %s to file: %v", name, err)
+	}
+	if err = tmpfile.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("completion of write to %s file: %v", name, err)
+	}
+
+	return tmpfile.Name(), cleanup, nil
+}
+
+// resourceGetter is implemented by both schema.ResourceData and
+// schema.ResourceDiff.
+type resourceGetter interface {
+	Get(key string) interface{}
+	GetOkExists(key string) (interface{}, bool)
+}
+
+// resourceConfig returns the provider configuration with the overrides set on
+// the resource applied.
+func resourceConfig(d resourceGetter, m interface{}) *config {
+	c := *m.(*config)
+	if kubeconfig := d.Get("kubeconfig").(string); kubeconfig != "" {
+		c.kubeconfig = kubeconfig
+		c.kubeconfigContent = ""
+		c.inCluster = false
+	}
+	if context := d.Get("context").(string); context != "" {
+		c.kubeconfigContext = context
+	}
+	return &c
+}
+
+// resourceNamespace returns the namespace of the resource, which defaults to the
+// one configured on the provider.
+func resourceNamespace(d resourceGetter, m interface{}) string {
+	if namespace := d.Get("namespace").(string); namespace != "" {
+		return namespace
+	}
+	return m.(*config).namespace
+}
+
+// resourceManifestNamespace returns the namespace the objects of a k8s_manifest
+// are applied into with kubectl -n, overriding the ones set in the manifest.
+// Unlike the resource's own namespace, the provider's default namespace only
+// fills in the namespace of the objects which don't set one, in the content.
+func resourceManifestNamespace(d resourceGetter) string {
+	return d.Get("namespace").(string)
+}
+
+// resourceValidate reports whether the manifest of the resource is validated by
+// kubectl, which defaults to the provider's kubectl_validate.
+func resourceValidate(d resourceGetter, m interface{}) bool {
+	if validate, ok := d.GetOkExists("validate"); ok {
+		return validate.(bool)
+	}
+	return m.(*config).validate
+}
+
+func kubectl(m interface{}, kubeconfig kubeconfigFiles, args ...string) *exec.Cmd {
+	// --kubeconfig takes a single file, a list of files is passed on through
+	// the KUBECONFIG environment variable instead.
+	var env []string
+	if strings.ContainsRune(kubeconfig.kubeconfig, filepath.ListSeparator) {
+		env = append(env, "KUBECONFIG="+kubeconfig.kubeconfig)
+	} else if kubeconfig.kubeconfig != "" {
+		args = append([]string{"--kubeconfig", kubeconfig.kubeconfig}, args...)
+	}
+	if kubeconfig.user != "" {
+		args = append([]string{"--user", kubeconfig.user}, args...)
+	}
+	if kubeconfig.clientCertificate != "" {
+		args = append([]string{"--client-certificate", kubeconfig.clientCertificate}, args...)
+	}
+	if kubeconfig.clientKey != "" {
+		args = append([]string{"--client-key", kubeconfig.clientKey}, args...)
+	}
+	if kubeconfig.certificateAuthority != "" {
+		args = append([]string{"--certificate-authority", kubeconfig.certificateAuthority}, args...)
+	}
+
+	context := m.(*config).kubeconfigContext
+	path := m.(*config).kubectlPath
+	token := m.(*config).kubectlToken
+	if kubeconfig.token != "" {
+		token = kubeconfig.token
+	}
+
+	if path == "" {
+		path = "kubectl"
+	}
+
+	if context != "" {
+		args = append([]string{"--context", context}, args...)
+	}
+
+	if token != "" {
+		args = append([]string{"--token", token}, args...)
+	}
+
+	if m.(*config).inCluster {
+		args = append(inClusterArgs(token == ""), args...)
+	}
+
+	if user := m.(*config).impersonateUser; user != "" {
+		args = append([]string{"--as", user}, args...)
+	}
+	for _, group := range m.(*config).impersonateGroups {
+		args = append([]string{"--as-group", group}, args...)
+	}
+
+	if timeout := m.(*config).requestTimeout; timeout != "" {
+		args = append([]string{"--request-timeout", timeout}, args...)
+	}
+
+	if host := m.(*config).host; host != "" {
+		args = append([]string{"--server", host}, args...)
+	}
+
+	if m.(*config).insecure {
+		args = append([]string{"--insecure-skip-tls-verify=true"}, args...)
+	}
+
+	// The extra arguments are unvalidated and have to be global flags, as they
+	// are passed before the command.
+	args = append(append([]string{}, m.(*config).extraArgs...), args...)
+
+	// The upper case variables take precedence over the lower case ones which
+	// may be inherited from the environment.
+	for name, value := range map[string]string{
+		"HTTP_PROXY":  m.(*config).httpProxy,
+		"HTTPS_PROXY": m.(*config).httpsProxy,
+		"NO_PROXY":    m.(*config).noProxy,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	cmd := exec.Command(path, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// serviceAccountDir is where the service account credentials are mounted into
+// pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// inClusterArgs returns the kubectl arguments to reach the API server from
+// inside a pod, authenticating with the mounted service account token unless
+// withToken is false.
+func inClusterArgs(withToken bool) []string {
+	server := "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	args := []string{
+		"--server", server,
+		"--certificate-authority", filepath.Join(serviceAccountDir, "ca.crt"),
+	}
+	if withToken {
+		// The token is read every time as the kubelet rotates it.
+		token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		if err != nil {
+			log.Printf("[WARN] reading service account token: %v", err)
+		} else {
+			args = append(args, "--token", strings.TrimSpace(string(token)))
+		}
+	}
+	return args
+}
+
+func resourceManifestCreate(d *schema.ResourceData, m interface{}) error {
+	m = resourceConfig(d, m)
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	if d.Get("create_namespace").(bool) {
+		namespaces := d.Get("namespaces").([]interface{})
+		if namespace := resourceNamespace(d, m); len(namespaces) == 0 && namespace != "" {
+			namespaces = []interface{}{namespace}
+		}
+		for _, namespace := range namespaces {
+			if err := createNamespace(m, kubeconfig, namespace.(string), d.Timeout(schema.TimeoutCreate)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.(*config).checkPermissions {
+		if err := resourceManifestCheckPermissions(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate)); err != nil {
+			return err
+		}
+	}
+
+	atomic := d.Get("atomic").(bool)
+	var existing []string
+	if atomic {
+		if existing, err = resourceManifestExistingObjects(d, m, kubeconfig); err != nil {
+			return err
+		}
+	}
+
+	if err := resourceManifestApply(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate)); err != nil {
+		if atomic {
+			return resourceManifestRollback(d, m, kubeconfig, existing, err)
+		}
+		return err
+	}
+
+	if err := resourceManifestSetID(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate)); err != nil {
+		return err
+	}
+
+	if err := resourceManifestWait(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate)); err != nil {
+		return err
+	}
+	return resourceManifestSetStatus(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate))
+}
+
+// createNamespace creates the namespace unless it exists, by applying the
+// manifest rendered by a dry run of kubectl create namespace.
+func createNamespace(m interface{}, kubeconfig kubeconfigFiles, namespace string, timeout time.Duration) error {
+	stdout := &bytes.Buffer{}
+	cmd := kubectl(m, kubeconfig, "create", "namespace", namespace, m.(*config).clientDryRunArg(), "-o", "yaml")
+	cmd.Stdout = stdout
+	if err := run(cmd); err != nil {
+		return fmt.Errorf("rendering namespace %s: %v", namespace, err)
+	}
+
+	return retry(m, timeout, func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, "apply", "-f", "-")
+		cmd.Stdin = bytes.NewReader(stdout.Bytes())
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+}
+
+// resourceManifestExistingObjects returns the IDs of the objects described by
+// the manifest which already exist. The objects are looked up one by one, since
+// the kinds of some of them may not be known yet, e.g. custom resources applied
+// along with their definition, which don't exist either.
+func resourceManifestExistingObjects(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles) ([]string, error) {
+	content, err := resourceManifestContent(d, m)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := manifestObjects(content, resourceManifestNamespace(d))
+	if err != nil {
+		return nil, fmt.Errorf("decoding manifest: %v", err)
+	}
+
+	var ids []string
+	for _, obj := range objects {
+		args := []string{"get", "--ignore-not-found", "--no-headers", "-o", objectColumns, obj.resource()}
+		if obj.namespace != "" {
+			args = append(args, "-n", obj.namespace)
+		}
+		cmd := kubectl(m, kubeconfig, args...)
+		stdout := &bytes.Buffer{}
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			if isUnmappedKind(err) {
+				continue
+			}
+			return nil, fmt.Errorf("looking up existing objects: %v", err)
+		}
+
+		data, err := parseObjectList(stdout.String())
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		found, err := data.ids()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+	return ids, nil
+}
+
+// unmappedKindErrors are the errors kubectl reports for kinds the API server
+// doesn't serve, e.g. custom resources whose definition isn't installed.
+var unmappedKindErrors = []string{
+	"no matches for kind",
+	"the server doesn't have a resource type",
+}
+
+// isUnmappedKind reports whether a kubectl command failed because of a kind the
+// API server doesn't serve.
+func isUnmappedKind(err error) bool {
+	output := errorOutput(err)
+	for _, message := range unmappedKindErrors {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceManifestRollback deletes the objects created by a failed apply,
+// leaving the ones which existed before alone, and returns the apply error.
+func resourceManifestRollback(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, existing []string, applyErr error) error {
+	current, err := resourceManifestExistingObjects(d, m, kubeconfig)
+	if err != nil {
+		return fmt.Errorf("%v, rolling back: %v", applyErr, err)
+	}
+
+	existed := map[string]bool{}
+	for _, id := range existing {
+		existed[id] = true
+	}
+	var created []string
+	for _, id := range current {
+		if !existed[id] {
+			created = append(created, id)
+		}
+	}
+
+	if err := deleteObjects(m, kubeconfig, created, d.Timeout(schema.TimeoutDelete)); err != nil {
+		return fmt.Errorf("%v, rolling back: %v", applyErr, err)
+	}
+	return fmt.Errorf("%v, rolled back %d created object(s)", applyErr, len(created))
+}
+
+// resourceManifestApply applies the content of the resource.
+func resourceManifestApply(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	content, err := resourceManifestApplyContent(d, m)
+	if err != nil {
+		return err
+	}
+	args := resourceManifestMethodArgs(d, m)
+
+	clientSideApply := args[0] == "apply" && !d.Get("server_side_apply").(bool)
+	if lastApplied := d.Get("last_applied_content").(string); clientSideApply && !d.IsNewResource() && lastApplied != "" {
+		resourceManifestSetLastApplied(d, m, kubeconfig, lastApplied)
+	}
+
+	// Namespaces and CRDs are applied first, so that the objects in them can be
+	// mapped by kubectl. Pruning needs all objects in a single apply, as it
+	// would delete the objects applied before.
+	phases := []string{content}
+	if !d.Get("prune").(bool) {
+		if phases, err = manifestPhases(content); err != nil {
+			return fmt.Errorf("splitting manifest: %v", err)
+		}
+	}
+
+	output := &bytes.Buffer{}
+	for i, phase := range phases {
+		var stdout *bytes.Buffer
+		err = retry(m, timeout, func() *resource.RetryError {
+			cmd := kubectl(m, kubeconfig, args...)
+			cmd.Stdin = strings.NewReader(phase)
+			stdout = &bytes.Buffer{}
+			cmd.Stdout = stdout
+			if err := run(cmd); err != nil {
+				return retryError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			if retryError(err).Retryable {
+				return err
+			}
+			if objectErr := failingObject(m, kubeconfig, args, phase); objectErr != nil {
+				return objectErr
+			}
+			return err
+		}
+		log.Printf("[DEBUG] kubectl output:\n%s", stdout.Bytes())
+		output.Write(stdout.Bytes())
+
+		if i < len(phases)-1 {
+			if err := waitForCRDs(m, kubeconfig, phase, timeout); err != nil {
+				return err
+			}
+		}
+	}
+	stdout := output
+
+	if err := d.Set("last_applied_content", content); err != nil {
+		return fmt.Errorf("setting last_applied_content: %v", err)
+	}
+	if err := d.Set("content_hash", manifestHash(content)); err != nil {
+		return fmt.Errorf("setting content_hash: %v", err)
+	}
+
+	results := applyResults(stdout.String())
+	if err := d.Set("last_apply_result", results); err != nil {
+		return fmt.Errorf("setting last_apply_result: %v", err)
+	}
+	if !d.IsNewResource() && d.HasChanges("content", "content_base64", "object", "kustomize_directory", "url") && allUnchanged(results) {
+		log.Printf("[WARN] the manifest of %s changed but kubectl reported every object as unchanged, "+
+			"some of the changed fields may have been dropped or ignored by the server", d.Id())
+	}
+	return nil
+}
+
+// failingObject finds the object of a manifest with multiple documents which
+// permanently failed to be applied with args, since kubectl doesn't always tell.
+// Every document is applied on its own in a dry run, the error of the first
+// failing one is returned. The dry run is server-side where kubectl supports it, so
+// that objects rejected by the API server are found as well. Documents created
+// before the failure, which kubectl create rejects as AlreadyExists, didn't fail.
+func failingObject(m interface{}, kubeconfig kubeconfigFiles, args []string, content string) error {
+	objects, err := decodeManifest(content)
+	if err != nil || len(objects) < 2 {
+		return nil
+	}
+	dryRunArg := m.(*config).clientDryRunArg()
+	if m.(*config).kubectlVersion.atLeast(serverSideKubectlVersion) {
+		dryRunArg = "--dry-run=server"
+	}
+	for _, object := range objects {
+		document, err := encodeManifest([]interface{}{object})
+		if err != nil {
+			return nil
+		}
+		cmd := kubectl(m, kubeconfig, append(append([]string{}, args...), dryRunArg)...)
+		cmd.Stdin = strings.NewReader(document)
+		if err := run(cmd); err != nil && !strings.Contains(errorOutput(err), "(AlreadyExists)") {
+			kind, _ := object["kind"].(string)
+			metadata, _ := object["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			return fmt.Errorf("applying %s %q: %w", kind, name, err)
+		}
+	}
+	return nil
+}
+
+// waitForCRDs waits until the CustomResourceDefinitions of the manifest are
+// established, as their custom resources can't be applied before.
+func waitForCRDs(m interface{}, kubeconfig kubeconfigFiles, content string, timeout time.Duration) error {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if kind, _ := object["kind"].(string); kind != "CustomResourceDefinition" {
+			continue
+		}
+		metadata, _ := object["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		crd := "customresourcedefinition/" + name
+		cmd := kubectl(m, kubeconfig, "wait", "--for=condition=established", "--timeout="+timeout.String(), crd)
+		if err := run(cmd); err != nil {
+			return fmt.Errorf("waiting for %s to be established: %v", crd, err)
+		}
+	}
+	return nil
+}
+
+// resourceManifestSetLastApplied records the previously applied content in the
+// last-applied-configuration annotation of the objects before an update. The
+// three-way merge of kubectl apply relies on it to remove fields which were
+// deleted from the manifest, and it may be missing or have been changed outside
+// of Terraform, e.g. by kubectl replace. Failures are only logged, since the apply
+// itself still works without it.
+func resourceManifestSetLastApplied(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, content string) {
+	args := []string{"apply", "set-last-applied", "--create-annotation=true", "-f", "-"}
+	if namespace := resourceManifestNamespace(d); namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	cmd := kubectl(m, kubeconfig, args...)
+	cmd.Stdin = strings.NewReader(content)
+	if err := run(cmd); err != nil {
+		log.Printf("[WARN] recording the last applied content of %s: %v", d.Id(), err)
+	}
+}
+
+// applyResults parses lines such as "deployment.apps/nginx configured" printed
+// by kubectl apply into a map of the objects to their results.
+func applyResults(output string) map[string]string {
+	results := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		results[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	return results
+}
+
+// allUnchanged reports whether kubectl reported every object as unchanged.
+func allUnchanged(results map[string]string) bool {
+	for _, result := range results {
+		if result != "unchanged" {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// resourceManifestContent returns the manifest of the resource. When the
+// object argument is used instead of content it is converted to YAML, a
+// kustomize_directory is rendered with kubectl kustomize. The namespace of the
+// resource takes precedence over the ones set in the manifest, and the objects
+// are sorted by kind if sort_by_kind is set.
+func resourceManifestContent(d resourceGetter, m interface{}) (string, error) {
+	content, err := resourceManifestSource(d, m)
+	if err != nil {
+		return "", err
+	}
+	if v := d.Get("vars").(map[string]interface{}); len(v) > 0 {
+		vars := map[string]string{}
+		for name, value := range v {
+			vars[name] = value.(string)
+		}
+		content = substituteVariables(content, vars)
+	}
+	if v, ok := d.Get("namespaces").([]interface{}); ok && len(v) > 0 {
+		var namespaces []string
+		for _, namespace := range v {
+			namespaces = append(namespaces, namespace.(string))
+		}
+		if content, err = fanOutNamespaces(content, namespaces); err != nil {
+			return "", fmt.Errorf("setting namespaces: %v", err)
+		}
+	}
+	if namespace := resourceManifestNamespace(d); namespace != "" {
+		if content, err = overrideNamespace(content, namespace); err != nil {
+			return "", fmt.Errorf("setting namespace: %v", err)
+		}
+	} else if namespace := m.(*config).namespace; namespace != "" {
+		if content, err = defaultNamespace(content, namespace); err != nil {
+			return "", fmt.Errorf("setting namespace: %v", err)
+		}
+	}
+	if secrets := d.Get("image_pull_secrets").([]interface{}); len(secrets) > 0 {
+		var names []string
+		for _, secret := range secrets {
+			names = append(names, secret.(string))
+		}
+		if content, err = addImagePullSecrets(content, names); err != nil {
+			return "", fmt.Errorf("adding image pull secrets: %v", err)
+		}
+	}
+	if d.Get("sort_by_kind").(bool) {
+		if content, err = sortManifest(content); err != nil {
+			return "", fmt.Errorf("sorting manifest: %v", err)
+		}
+	}
+	return content, nil
+}
+
+// resourceManifestSource returns the manifest as configured on the resource.
+func resourceManifestSource(d resourceGetter, m interface{}) (string, error) {
+	if manifestURL := d.Get("url").(string); manifestURL != "" {
+		content, err := fetchManifest(manifestURL)
+		if err != nil {
+			return "", fmt.Errorf("fetching %s: %v", manifestURL, err)
+		}
+		return content, nil
+	}
+	if directory := d.Get("kustomize_directory").(string); directory != "" {
+		stdout := &bytes.Buffer{}
+		cmd := kubectl(m, kubeconfigFiles{}, "kustomize", directory)
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return "", fmt.Errorf("rendering %s: %v", directory, err)
+		}
+		return stdout.String(), nil
+	}
+
+	if encoded := d.Get("content_base64").(string); encoded != "" {
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", fmt.Errorf("decoding content_base64: %v", err)
+		}
+		return string(content), nil
+	}
+
+	object := d.Get("object").(string)
+	if object == "" {
+		return d.Get("content").(string), nil
+	}
+	data, err := yaml.JSONToYAML([]byte(object))
+	if err != nil {
+		return "", fmt.Errorf("converting object to YAML: %v", err)
+	}
+	return string(data), nil
+}
+
+// resourceManifestApplyContent returns the manifest which is applied, i.e. the
+// content annotated with managedByAnnotation if managed_by is configured and
+// with changeCauseAnnotation if the resource sets change_cause.
+func resourceManifestApplyContent(d resourceGetter, m interface{}) (string, error) {
+	content, err := resourceManifestContent(d, m)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range map[string]string{
+		managedByAnnotation:   m.(*config).managedBy,
+		changeCauseAnnotation: d.Get("change_cause").(string),
+	} {
+		if value == "" {
+			continue
+		}
+		if content, err = annotateManifest(content, key, value); err != nil {
+			return "", fmt.Errorf("annotating manifest: %v", err)
+		}
+	}
+	return content, nil
+}
+
+// resourceManifestMethodArgs returns the arguments of the kubectl command
+// selected by apply_method. With create new objects are created with kubectl
+// create --save-config, so that they can be updated with kubectl apply later.
+// With replace they are replaced with kubectl replace --force, which deletes
+// and recreates them.
+func resourceManifestMethodArgs(d *schema.ResourceData, m interface{}) []string {
+	var args []string
+	switch d.Get("apply_method").(string) {
+	case "create":
+		if !d.IsNewResource() {
+			return resourceManifestApplyArgs(d, m)
+		}
+		args = []string{"create", "--save-config", "-f", "-"}
+	case "replace":
+		args = []string{"replace", "--force", "-f", "-"}
+	default:
+		return resourceManifestApplyArgs(d, m)
+	}
+	if namespace := resourceManifestNamespace(d); namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if !resourceValidate(d, m) {
+		args = append(args, "--validate=false")
+	}
+	if fieldManager := m.(*config).fieldManager; fieldManager != "" {
+		args = append(args, "--field-manager="+fieldManager)
+	}
+	return append(args, resourceManifestExtraArgs(d)...)
+}
+
+// resourceManifestExtraArgs returns the unvalidated extra_args of the resource,
+// which are appended to the command applying the manifest.
+func resourceManifestExtraArgs(d resourceGetter) []string {
+	var args []string
+	for _, arg := range d.Get("extra_args").([]interface{}) {
+		args = append(args, arg.(string))
+	}
+	return args
+}
+
+// resourceManifestApplyArgs returns the arguments of the kubectl apply command
+// for the resource.
+func resourceManifestApplyArgs(d resourceGetter, m interface{}) []string {
+	args := []string{"apply", "-f", "-"}
+	if namespace := resourceManifestNamespace(d); namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if !resourceValidate(d, m) {
+		args = append(args, "--validate=false")
+	}
+	serverSide := d.Get("server_side_apply").(bool)
+	if serverSide {
+		args = append(args, "--server-side")
+		if d.Get("force_conflicts").(bool) {
+			args = append(args, "--force-conflicts")
+		}
+	} else if !d.Get("overwrite").(bool) {
+		args = append(args, "--overwrite=false")
+	}
+	if d.Get("prune").(bool) {
+		args = append(args, "--prune", "-l", d.Get("prune_selector").(string))
+	}
+	// Older kubectl versions don't know about field managers, so the
+	// default one is only passed along with server-side applies.
+	if fieldManager := m.(*config).fieldManager; fieldManager != "" {
+		args = append(args, "--field-manager="+fieldManager)
+	} else if serverSide {
+		args = append(args, "--field-manager="+defaultFieldManager)
+	}
+	return append(args, resourceManifestExtraArgs(d)...)
+}
+
+// resourceManifestCustomizeDiff validates the resource arguments and, when
+// server_dry_run is set, the planned content against the API server with a
+// server-side dry run, so that manifests rejected by admission webhooks fail at
+// plan time.
+func resourceManifestCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	// Pruning without a selector would delete every object of the pruned
+	// kinds which isn't part of the content.
+	if d.Get("prune").(bool) && d.Get("prune_selector").(string) == "" {
+		return fmt.Errorf("prune_selector has to be set when prune is enabled")
+	}
+	if d.Get("apply_method").(string) != "apply" && (d.Get("server_side_apply").(bool) || d.Get("prune").(bool)) {
+		return fmt.Errorf("server_side_apply and prune require apply_method to be apply")
+	}
+	if !d.Get("overwrite").(bool) && (d.Get("server_side_apply").(bool) || d.Get("apply_method").(string) != "apply") {
+		return fmt.Errorf("overwrite can only be disabled for client-side applies")
+	}
+	if waitFor := d.Get("wait_for").([]interface{}); len(waitFor) > 0 && waitFor[0] != nil {
+		block := waitFor[0].(map[string]interface{})
+		if (block["condition"].(string) == "") == (block["jsonpath"].(string) == "") {
+			return fmt.Errorf("wait_for has to set either condition or jsonpath")
+		}
+	}
+
+	c := m.(*config)
+	if d.Get("server_side_apply").(bool) {
+		if err := c.requireKubectl(serverSideKubectlVersion, "server_side_apply"); err != nil {
+			return err
+		}
+	}
+	if d.Get("server_dry_run").(bool) {
+		if err := c.requireKubectl(serverSideKubectlVersion, "server_dry_run"); err != nil {
+			return err
+		}
+	}
+	if d.Get("kustomize_directory").(string) != "" {
+		if err := c.requireKubectl(kustomizeKubectlVersion, "kustomize_directory"); err != nil {
+			return err
+		}
+	}
+	if d.Get("delete_cascade").(string) == "foreground" {
+		if err := c.requireKubectl(cascadePolicyKubectlVersion, "foreground delete_cascade"); err != nil {
+			return err
+		}
+	}
+
+	if d.Id() != "" && manifestSourceKnown(d) {
+		if err := resourceManifestDiffContent(d, m); err != nil {
+			return err
+		}
+	}
+
+	if d.Id() != "" && c.kubectlVersion.atLeast(diffKubectlVersion) && (d.HasChange("content") ||
+		d.HasChange("content_base64") || d.HasChange("object") || d.HasChange("kustomize_directory") || d.HasChange("url") || d.HasChange("content_hash")) {
+		if err := resourceManifestPlan(d, m); err != nil {
+			return err
+		}
+	}
+
+	if !d.Get("server_dry_run").(bool) || !d.NewValueKnown("content") || !d.NewValueKnown("content_base64") ||
+		!d.NewValueKnown("object") || !d.NewValueKnown("kustomize_directory") || !d.NewValueKnown("url") {
+		return nil
+	}
+	if d.Id() != "" && !d.HasChange("content") && !d.HasChange("content_base64") && !d.HasChange("object") &&
+		!d.HasChange("kustomize_directory") && !d.HasChange("url") {
+		return nil
+	}
+
+	m = resourceConfig(d, m)
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	content, err := resourceManifestApplyContent(d, m)
+	if err != nil {
+		return err
+	}
+
+	args := append(resourceManifestApplyArgs(d, m), "--dry-run=server")
+	cmd := kubectl(m, kubeconfig, args...)
+	cmd.Stdin = strings.NewReader(content)
+	if err := run(cmd); err != nil {
+		return fmt.Errorf("server-side dry run: %v", err)
+	}
+	return nil
+}
+
+// manifestSources are the arguments the manifest of a k8s_manifest can be read
+// from, one of which is set.
+var manifestSources = []string{"content", "content_base64", "object", "kustomize_directory", "url"}
+
+// manifestSourceKnown reports whether the planned values of the arguments the
+// manifest is rendered from are known.
+func manifestSourceKnown(d *schema.ResourceDiff) bool {
+	for _, key := range append(manifestSources, "vars", "namespaces") {
+		if !d.NewValueKnown(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceManifestDiffContent compares the planned manifest, as rendered for
+// the apply, to the one last applied. The manifest behind a URL or
+// kustomization directory can change without a change of the arguments, and
+// refresh records drift of the live objects in content_hash, so an update is
+// planned whenever the checksum of the rendered manifest differs from
+// content_hash. Applying a manifest describing other objects would leave the
+// old ones behind, so the resource is replaced instead.
+func resourceManifestDiffContent(d *schema.ResourceDiff, m interface{}) error {
+	content, err := resourceManifestApplyContent(d, resourceConfig(d, m))
+	if err != nil {
+		return err
+	}
+	// States written before content_hash was tracked for every source have
+	// no checksum, which isn't a change of the manifest, nor is the checksum
+	// of the text of the same manifest written by earlier versions.
+	known := d.Get("content_hash").(string)
+	if known != "" && manifestHash(content) != known && rawManifestHash(content) != known {
+		if err := d.SetNew("content_hash", manifestHash(content)); err != nil {
+			return err
+		}
+	}
+
+	if !manifestDropsObjects(resourceManifestObjectIDs(d.Id()), content) {
+		return nil
+	}
+	for _, key := range append(manifestSources, "vars") {
+		if d.HasChange(key) {
+			return d.ForceNew(key)
+		}
+	}
+	// The objects move to another namespace because the default namespace of
+	// the provider changed, which changes the rendered manifest only.
+	if !d.HasChange("content_hash") {
+		if err := d.SetNew("content_hash", manifestHash(content)); err != nil {
+			return err
+		}
+	}
+	if !d.HasChange("content_hash") {
+		return nil
+	}
+	return d.ForceNew("content_hash")
+}
+
+// resourceManifestPlan sets the plan attribute to the changes kubectl diff
+// reports for the planned manifest. Failures are only logged, since the plan
+// is informational.
+func resourceManifestPlan(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("content") || !d.NewValueKnown("content_base64") || !d.NewValueKnown("object") ||
+		!d.NewValueKnown("kustomize_directory") || !d.NewValueKnown("url") {
+		return d.SetNewComputed("plan")
+	}
+
+	m = resourceConfig(d, m)
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	content, err := resourceManifestApplyContent(d, m)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"diff", "-f", "-"}
+	if namespace := resourceManifestNamespace(d); namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	if d.Get("server_side_apply").(bool) {
+		args = append(args, "--server-side")
+	}
+	cmd := kubectl(m, kubeconfig, args...)
+	cmd.Stdin = strings.NewReader(content)
+	// kubectl diff exits with 1 when it found differences.
+	stdout, _, err := runAllowingExitCodes(cmd, 1)
+	if err != nil {
+		log.Printf("[WARN] planning the changes of %s: %v", d.Id(), err)
+		return nil
+	}
+	return d.SetNew("plan", stdout)
+}
+
+// resourceManifestSetID looks up the objects described by the content and
+// stores their IDs, separated by commas, as the resource ID.
+func resourceManifestSetID(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	namespace := resourceManifestNamespace(d)
+	content, err := resourceManifestContent(d, m)
+	if err != nil {
+		return err
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, timeout, func() *resource.RetryError {
+		stdout = &bytes.Buffer{}
+		var cmd *exec.Cmd
+		if namespace != "" {
+			cmd = kubectl(m, kubeconfig, "get", "--no-headers", "-o", objectColumns, "-n", namespace, "-f", "-")
+		} else {
+			cmd = kubectl(m, kubeconfig, "get", "--no-headers", "-o", objectColumns, "-f", "-")
+		}
+		cmd.Stdin = strings.NewReader(content)
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := parseObjectList(stdout.String())
+	if err != nil {
+		return err
+	}
+	ids, err := data.ids()
+	if err != nil {
+		return err
+	}
+	d.SetId(strings.Join(ids, ","))
+	if err := d.Set("objects", data.objects()); err != nil {
+		return fmt.Errorf("setting objects: %v", err)
+	}
+	if err := d.Set("resource_versions", data.resourceVersions()); err != nil {
+		return fmt.Errorf("setting resource_versions: %v", err)
+	}
+	if err := d.Set("generations", data.generations()); err != nil {
+		return fmt.Errorf("setting generations: %v", err)
+	}
+
+	first := data[0]
+	return resourceManifestSetAttributes(d, first.apiVersion, first.kind, first.name, first.uid)
+}
+
+// objectColumns is the kubectl get output format listing the fields of the
+// objects which identify them, one object per line. Unlike a jsonpath template
+// it works for a single object as well as for lists.
+const objectColumns = "custom-columns=API_VERSION:.apiVersion,KIND:.kind,NAMESPACE:.metadata.namespace," +
+	"NAME:.metadata.name,UID:.metadata.uid,RESOURCE_VERSION:.metadata.resourceVersion," +
+	"GENERATION:.metadata.generation"
+
+// listedObject is an object listed by kubectl get -o objectColumns.
+type listedObject struct {
+	manifestObject
+	uid             string
+	resourceVersion string
+	generation      string
+}
+
+// objectList are the objects listed by kubectl get -o objectColumns.
+type objectList []listedObject
+
+// parseObjectList parses the output of kubectl get --no-headers -o
+// objectColumns.
+func parseObjectList(output string) (objectList, error) {
+	var list objectList
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("could not parse object from %q", line)
+		}
+		// Missing fields, e.g. the namespace of cluster-scoped objects, are
+		// printed as <none>.
+		for i := range fields {
+			if fields[i] == "<none>" {
+				fields[i] = ""
+			}
+		}
+		list = append(list, listedObject{
+			manifestObject: manifestObject{
+				apiVersion: fields[0],
+				kind:       fields[1],
+				namespace:  fields[2],
+				name:       fields[3],
+			},
+			uid:             fields[4],
+			resourceVersion: fields[5],
+			generation:      fields[6],
+		})
+	}
+	return list, nil
+}
+
+// ids returns the IDs of the listed objects, failing if there are none.
+func (l objectList) ids() ([]string, error) {
+	if len(l) == 0 {
+		return nil, fmt.Errorf("expected to create at least 1 resource, got none")
+	}
+	ids := make([]string, 0, len(l))
+	for _, obj := range l {
+		if obj.apiVersion == "" || obj.kind == "" || obj.name == "" {
+			return nil, fmt.Errorf("could not parse object identity from %s %s", obj.kind, obj.name)
+		}
+		ids = append(ids, obj.id())
+	}
+	return ids, nil
+}
+
+// objects returns the listed objects in the form of the objects attribute.
+func (l objectList) objects() []interface{} {
+	objects := make([]interface{}, 0, len(l))
+	for _, obj := range l {
+		objects = append(objects, map[string]interface{}{
+			"api_version": obj.apiVersion,
+			"kind":        obj.kind,
+			"name":        obj.name,
+			"namespace":   obj.namespace,
+		})
+	}
+	return objects
+}
+
+// resourceVersions returns the resource versions of the listed objects by their
+// IDs.
+func (l objectList) resourceVersions() map[string]interface{} {
+	versions := make(map[string]interface{}, len(l))
+	for _, obj := range l {
+		versions[obj.id()] = obj.resourceVersion
+	}
+	return versions
+}
+
+// generations returns the generations of the listed objects which have one by
+// their IDs.
+func (l objectList) generations() map[string]interface{} {
+	generations := make(map[string]interface{}, len(l))
+	for _, obj := range l {
+		if obj.generation != "" {
+			generations[obj.id()] = obj.generation
+		}
+	}
+	return generations
+}
+
+// resourceManifestSetAttributes sets the computed attributes describing the
+// first object of the manifest.
+func resourceManifestSetAttributes(d *schema.ResourceData, apiVersion, kind, name, uid string) error {
+	for key, value := range map[string]string{
+		"api_version": apiVersion,
+		"kind":        kind,
+		"name":        name,
+		"uid":         uid,
+	} {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("setting %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// resourceManifestSetStatus sets the status attribute to the status of the
+// first object of the resource, which is refreshed by Read as well.
+func resourceManifestSetStatus(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	k8sResource, namespace, ok := resourceFromID(resourceManifestObjectIDs(d.Id())[0])
+	if !ok {
+		return fmt.Errorf("invalid resource id: %s", d.Id())
+	}
+	stdout, found, err := getObject(m, kubeconfig, k8sResource, namespace, "json", timeout)
+	if err != nil || !found {
+		return err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &object); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	return setObjectStatus(d, object)
+}
+
+// setObjectStatus sets the status attribute to the status of the live object
+// as JSON, or an empty string if it has none.
+func setObjectStatus(d *schema.ResourceData, object map[string]interface{}) error {
+	var status string
+	if object["status"] != nil {
+		data, err := json.Marshal(object["status"])
+		if err != nil {
+			return fmt.Errorf("encoding status: %v", err)
+		}
+		status = string(data)
+	}
+	if err := d.Set("status", status); err != nil {
+		return fmt.Errorf("setting status: %v", err)
+	}
+	return nil
+}
+
+func resourceManifestUpdate(d *schema.ResourceData, m interface{}) error {
+	m = resourceConfig(d, m)
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	if m.(*config).checkPermissions {
+		if err := resourceManifestCheckPermissions(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return err
+		}
+	}
+	if err := resourceManifestCheckResourceVersions(d, m, kubeconfig); err != nil {
+		return err
+	}
+
+	err = resourceManifestApply(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate))
+	if err != nil && d.Get("recreate_on_conflict").(bool) && strings.Contains(errorOutput(err), "field is immutable") {
+		err = resourceManifestRecreate(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The content may now describe a different set of objects.
+	if err := resourceManifestSetID(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate)); err != nil {
+		return err
+	}
+
+	if err := resourceManifestWait(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate)); err != nil {
+		return err
+	}
+	return resourceManifestSetStatus(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate))
+}
+
+// resourceManifestCheckResourceVersions compares the generations of the live
+// objects to the ones in the state, which were seen by the last refresh. An
+// object changed since then by another actor would be overwritten by the
+// update, which is an error with optimistic_lock and a warning otherwise. The
+// resource version also changes with every write of the status, e.g. by the
+// controller of a Deployment, so it is only compared for objects without a
+// generation.
+func resourceManifestCheckResourceVersions(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles) error {
+	knownVersions := d.Get("resource_versions").(map[string]interface{})
+	knownGenerations := d.Get("generations").(map[string]interface{})
+	for _, id := range resourceManifestObjectIDs(d.Id()) {
+		field, jsonpath := "generation", "{.metadata.generation}"
+		expected, ok := knownGenerations[id].(string)
+		if !ok || expected == "" {
+			field, jsonpath = "resource version", "{.metadata.resourceVersion}"
+			expected, ok = knownVersions[id].(string)
+		}
+		if !ok || expected == "" {
+			continue
+		}
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", d.Id())
+		}
+		stdout, found, err := getObject(m, kubeconfig, k8sResource, namespace, "jsonpath="+jsonpath,
+			d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if live := strings.TrimSpace(stdout.String()); live != expected {
+			if d.Get("optimistic_lock").(bool) {
+				return fmt.Errorf("%s was changed since the last refresh (%s %s, expected %s), "+
+					"refresh and plan again", k8sResource, field, live, expected)
+			}
+			log.Printf("[WARN] %s was changed since the last refresh (%s %s, expected %s), "+
+				"the update overwrites the changes", k8sResource, field, live, expected)
+		}
+	}
+	return nil
+}
+
+// resourceManifestRecreate deletes the objects of the resource, waits until they
+// are gone and applies the manifest again, for changes to immutable fields
+// which can't be applied to the existing objects.
+func resourceManifestRecreate(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	log.Printf("[INFO] recreating the objects of %s to change immutable fields", d.Id())
+	ids := resourceManifestObjectIDs(d.Id())
+	if err := deleteObjects(m, kubeconfig, ids, timeout, resourceManifestDeleteArgs(d, m)...); err != nil {
+		return err
 	}
-	return nil
+	if err := waitForDeletion(m, kubeconfig, ids, timeout); err != nil {
+		return err
+	}
+	return resourceManifestApply(d, m, kubeconfig, timeout)
 }
 
-func kubeconfigPath(m interface{}) (string, func(), error) {
-	kubeconfig := m.(*config).kubeconfig
-	kubeconfigContent := m.(*config).kubeconfigContent
-	var cleanupFunc = func() {}
+// rolloutKinds are the kinds supported by kubectl rollout status.
+var rolloutKinds = map[string]bool{
+	"DaemonSet":   true,
+	"Deployment":  true,
+	"StatefulSet": true,
+}
 
-	if kubeconfig != "" && kubeconfigContent != "" {
-		return kubeconfig, cleanupFunc, fmt.Errorf("both kubeconfig and kubeconfig_content are defined, " +
-			"please use only one of the paramters")
-	} else if kubeconfigContent != "" {
-		tmpfile, err := ioutil.TempFile("", "kubeconfig_")
-		if err != nil {
-			defer cleanupFunc()
-			return "", cleanupFunc, fmt.Errorf("creating a kubeconfig file: %v", err)
+// resourceManifestWait waits for the rollout of the workloads of the resource
+// to complete when wait_for_rollout is set, then for the condition (or the
+// JSONPath value) configured in the wait_for block to be met by every object.
+// Unless the block sets a timeout, the timeout of the operation is used.
+func resourceManifestWait(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	if d.Get("wait_for_rollout").(bool) {
+		for _, id := range resourceManifestObjectIDs(d.Id()) {
+			obj, ok := parseManifestObject(id)
+			if !ok || !rolloutKinds[obj.kind] {
+				continue
+			}
+			args := []string{"rollout", "status", "--timeout=" + timeout.String(), obj.resource()}
+			if obj.namespace != "" {
+				args = append(args, "-n", obj.namespace)
+			}
+			if err := run(kubectl(m, kubeconfig, args...)); err != nil {
+				return fmt.Errorf("waiting for the rollout of %s: %v", obj.resource(), err)
+			}
 		}
+	}
 
-		cleanupFunc = func() { os.Remove(tmpfile.Name()) }
+	waitFor := d.Get("wait_for").([]interface{})
+	if len(waitFor) == 0 || waitFor[0] == nil {
+		return nil
+	}
+	block := waitFor[0].(map[string]interface{})
+	condition := block["condition"].(string)
+	jsonpath := block["jsonpath"].(string)
+	if t := block["timeout"].(string); t != "" {
+		// Validated by the schema.
+		timeout, _ = time.ParseDuration(t)
+	}
 
-		if _, err = io.WriteString(tmpfile, kubeconfigContent); err != nil {
-			defer cleanupFunc()
-			return "", cleanupFunc, fmt.Errorf("writing kubeconfig to file: %v", err)
+	for _, id := range resourceManifestObjectIDs(d.Id()) {
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", d.Id())
 		}
-		if err = tmpfile.Close(); err != nil {
-			defer cleanupFunc()
-			return "", cleanupFunc, fmt.Errorf("completion of write to kubeconfig file: %v", err)
+		if jsonpath != "" {
+			if err := waitForValue(m, kubeconfig, k8sResource, namespace, jsonpath, block["value"].(string), timeout); err != nil {
+				return err
+			}
+			continue
+		}
+		args := []string{"wait", "--for=condition=" + condition, "--timeout=" + timeout.String(), k8sResource}
+		if namespace != "" {
+			args = append(args, "-n", namespace)
+		}
+		if err := run(kubectl(m, kubeconfig, args...)); err != nil {
+			return fmt.Errorf("waiting for %s to be %s: %v", k8sResource, condition, err)
 		}
-
-		kubeconfig = tmpfile.Name()
 	}
+	return nil
+}
 
-	if kubeconfig != "" {
-		return kubeconfig, cleanupFunc, nil
+// waitForValue polls the field of the object at jsonpath until it equals value,
+// for status fields kubectl wait can't wait for, e.g. .status.phase.
+func waitForValue(m interface{}, kubeconfig kubeconfigFiles, k8sResource, namespace, jsonpath, value string, timeout time.Duration) error {
+	args := []string{"get", "-o", "jsonpath=" + jsonpathTemplate(jsonpath), k8sResource}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
 	}
+	err := resource.Retry(timeout, func() *resource.RetryError {
+		stdout := &bytes.Buffer{}
+		cmd := kubectl(m, kubeconfig, args...)
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		if actual := stdout.String(); actual != value {
+			return resource.RetryableError(fmt.Errorf("%s of %s is %q", jsonpath, k8sResource, actual))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s of %s to be %q: %v", jsonpath, k8sResource, value, err)
+	}
+	return nil
+}
+
+// resourceManifestObjectIDs splits the resource ID into the IDs of the objects
+// managed by the resource.
+func resourceManifestObjectIDs(id string) []string {
+	return strings.Split(id, ",")
+}
+
+// manifestObject identifies a single Kubernetes object.
+type manifestObject struct {
+	apiVersion string
+	kind       string
+	namespace  string
+	name       string
+}
+
+// id returns the object ID in the form apiVersion/kind/namespace/name. The
+// namespace is left empty for cluster-scoped objects.
+func (o manifestObject) id() string {
+	return strings.Join([]string{o.apiVersion, o.kind, o.namespace, o.name}, "/")
+}
 
-	return "", cleanupFunc, nil
+// resource returns the fully qualified kind/name argument understood by
+// kubectl, e.g. Deployment.v1.apps/nginx.
+func (o manifestObject) resource() string {
+	return o.qualifiedKind() + "/" + o.name
 }
 
-func kubectl(m interface{}, kubeconfig string, args ...string) *exec.Cmd {
-	if kubeconfig != "" {
-		args = append([]string{"--kubeconfig", kubeconfig}, args...)
+// qualifiedKind returns the kind qualified with the version and group of the
+// object as understood by kubectl, e.g. Deployment.v1.apps.
+func (o manifestObject) qualifiedKind() string {
+	kind := o.kind
+	if i := strings.LastIndex(o.apiVersion, "/"); i >= 0 {
+		kind += "." + o.apiVersion[i+1:] + "." + o.apiVersion[:i]
 	}
+	return kind
+}
 
-	context := m.(*config).kubeconfigContext
-	path := m.(*config).kubectlPath
-	token := m.(*config).kubectlToken
+// parseManifestObject parses an object ID created by manifestObject.id. The
+// apiVersion may itself contain a slash so the ID is parsed from the end.
+func parseManifestObject(id string) (manifestObject, bool) {
+	parts := strings.Split(id, "/")
+	if len(parts) < 4 {
+		return manifestObject{}, false
+	}
+	n := len(parts)
+	obj := manifestObject{
+		apiVersion: strings.Join(parts[:n-3], "/"),
+		kind:       parts[n-3],
+		namespace:  parts[n-2],
+		name:       parts[n-1],
+	}
+	if obj.apiVersion == "" || obj.kind == "" || obj.name == "" {
+		return manifestObject{}, false
+	}
+	return obj, true
+}
 
-	if path == "" {
-		path = "kubectl"
+// matches reports whether o and other are the same object. An object without a
+// namespace matches the object with the same name in any namespace, since its
+// namespace is only decided when it is applied.
+func (o manifestObject) matches(other manifestObject) bool {
+	return o.apiVersion == other.apiVersion && o.kind == other.kind && o.name == other.name &&
+		(o.namespace == "" || other.namespace == "" || o.namespace == other.namespace)
+}
+
+// manifestObjects returns the objects described by the manifest. namespace, if
+// set, takes precedence over the namespaces of the objects, like kubectl -n.
+func manifestObjects(content, namespace string) ([]manifestObject, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return nil, err
+	}
+	described := make([]manifestObject, 0, len(objects))
+	for _, object := range objects {
+		obj := manifestObject{namespace: namespace}
+		obj.apiVersion, _ = object["apiVersion"].(string)
+		obj.kind, _ = object["kind"].(string)
+		metadata, _ := object["metadata"].(map[string]interface{})
+		obj.name, _ = metadata["name"].(string)
+		if ns, _ := metadata["namespace"].(string); obj.namespace == "" {
+			obj.namespace = ns
+		}
+		described = append(described, obj)
 	}
+	return described, nil
+}
 
-	if context != "" {
-		args = append([]string{"--context", context}, args...)
+// resourceFromID returns the kubectl resource argument and the namespace of the
+// object with the given ID. Resources created by earlier versions of the
+// provider are identified by their self-link, which is still understood.
+func resourceFromID(id string) (resource, namespace string, ok bool) {
+	if strings.HasPrefix(id, "/") {
+		return resourceFromSelflink(id)
+	}
+	obj, ok := parseManifestObject(id)
+	if !ok {
+		return "", "", false
 	}
+	if clusterScopedKinds[obj.kind] {
+		obj.namespace = ""
+	}
+	return obj.resource(), obj.namespace, true
+}
 
-	if token != "" {
-		args = append([]string{"--token", token}, args...)
+// clusterScopedKinds are the built-in kinds which aren't namespaced, so that
+// kubectl is never passed a namespace for them. Other cluster-scoped objects
+// are recognized by the empty namespace in their IDs.
+var clusterScopedKinds = map[string]bool{
+	"APIService":                     true,
+	"CertificateSigningRequest":      true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"PriorityClass":                  true,
+	"RuntimeClass":                   true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+	"VolumeAttachment":               true,
+}
+
+func resourceFromSelflink(s string) (resource, namespace string, ok bool) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 {
+		return "", "", false
 	}
+	resource = parts[len(parts)-2] + "/" + parts[len(parts)-1]
 
-	return exec.Command(path, args...)
+	// The self-link of a Namespace ends in namespaces/<name>, only namespaced
+	// objects have further segments after the namespace.
+	for i, part := range parts {
+		if part == "namespaces" && len(parts) > i+2 {
+			namespace = parts[i+1]
+			break
+		}
+	}
+	return resource, namespace, true
 }
 
-func resourceManifestCreate(d *schema.ResourceData, m interface{}) error {
+func resourceManifestDelete(d *schema.ResourceData, m interface{}) error {
+	m = resourceConfig(d, m)
 	kubeconfig, cleanup, err := kubeconfigPath(m)
 	if err != nil {
 		return fmt.Errorf("determining kubeconfig: %v", err)
 	}
 	defer cleanup()
 
-	namespace, isNamespace := d.GetOk("namespace")
-	shouldValidate := d.Get("validate")
-
-	var cmd *exec.Cmd
-
-	err = resource.Retry(d.Timeout(schema.TimeoutCreate), func() *resource.RetryError {
-		args := []string{"apply", "-f", "-"}
-		if isNamespace {
-			args = append(args, "-n", namespace.(string))
-		}
-		if !shouldValidate.(bool) {
-			args = append(args, "--validate=false")
+	ids := resourceManifestObjectIDs(d.Id())
+	if d.Get("check_delete_permissions").(bool) || m.(*config).checkPermissions {
+		if err := checkPermissions(m, kubeconfig, "delete", ids, d.Timeout(schema.TimeoutDelete)); err != nil {
+			return err
 		}
-
-		cmd = kubectl(m, kubeconfig, args...)
-		cmd.Stdin = strings.NewReader(d.Get("content").(string))
-		if err := run(cmd); err != nil {
-			return resource.RetryableError(err)
+	}
+	deleteArgs := resourceManifestDeleteArgs(d, m)
+	if selector := d.Get("delete_selector").(string); selector != "" {
+		err := deleteBySelector(m, kubeconfig, ids, selector, d.Timeout(schema.TimeoutDelete), deleteArgs...)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
+		// The tracked objects may have been deleted by now.
+		deleteArgs = append(deleteArgs, "--ignore-not-found")
+	}
+	err = deleteObjects(m, kubeconfig, ids, d.Timeout(schema.TimeoutDelete), deleteArgs...)
 	if err != nil {
 		return err
 	}
 
-	var stdout *bytes.Buffer
-	err = resource.Retry(d.Timeout(schema.TimeoutCreate), func() *resource.RetryError {
-		stdout = &bytes.Buffer{}
-		if isNamespace {
-			cmd = kubectl(m, kubeconfig, "get", "-o", "json", "-n", namespace.(string), "-f", "-")
-		} else {
-			cmd = kubectl(m, kubeconfig, "get", "-o", "json", "-f", "-")
+	if d.Get("wait_for_delete").(bool) {
+		timeout := d.Timeout(schema.TimeoutDelete)
+		if t := d.Get("delete_wait_timeout").(string); t != "" {
+			// Validated by the schema.
+			timeout, _ = time.ParseDuration(t)
 		}
-		cmd.Stdin = strings.NewReader(d.Get("content").(string))
-		cmd.Stdout = stdout
-		if err := run(cmd); err != nil {
-			return resource.RetryableError(err)
+		return waitForDeletion(m, kubeconfig, ids, timeout)
+	}
+	return nil
+}
+
+// resourceManifestDeleteArgs returns the extra arguments of the kubectl delete
+// command for the resource.
+func resourceManifestDeleteArgs(d *schema.ResourceData, m interface{}) []string {
+	var args []string
+	if cascade := d.Get("delete_cascade").(string); cascade != "" {
+		// kubectl only accepts the deletion propagation policy since 1.20,
+		// before that --cascade=false orphans the dependents.
+		if !m.(*config).kubectlVersion.atLeast(cascadePolicyKubectlVersion) {
+			cascade = strconv.FormatBool(cascade != "orphan")
 		}
-		return nil
-	})
+		args = append(args, "--cascade="+cascade)
+	}
+	gracePeriod := d.Get("delete_grace_period").(int)
+	if d.Get("delete_force").(bool) {
+		args = append(args, "--force")
+		// Older kubectl versions only force deletion without a grace period.
+		if gracePeriod < 0 {
+			gracePeriod = 0
+		}
+	}
+	if gracePeriod >= 0 {
+		args = append(args, "--grace-period="+strconv.Itoa(gracePeriod))
+	}
+	return args
+}
+
+// resourceManifestCheckPermissions verifies that the user may perform the verbs
+// needed to apply every object described by the content of the resource, which
+// depend on the apply_method and on whether the object exists already.
+func resourceManifestCheckPermissions(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	content, err := resourceManifestContent(d, m)
 	if err != nil {
 		return err
 	}
-
-	var data struct {
-		Items []struct {
-			Metadata struct {
-				Selflink string `json:"selflink"`
-			} `json:"metadata"`
-		} `json:"items"`
+	objects, err := manifestObjects(content, resourceManifestNamespace(d))
+	if err != nil {
+		return fmt.Errorf("decoding manifest: %v", err)
 	}
-	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
-		return fmt.Errorf("decoding response: %v", err)
+	existingIDs, err := resourceManifestExistingObjects(d, m, kubeconfig)
+	if err != nil {
+		return err
+	}
+	var existing []manifestObject
+	for _, id := range existingIDs {
+		if obj, ok := parseManifestObject(id); ok {
+			existing = append(existing, obj)
+		}
 	}
-	if len(data.Items) != 1 {
-		return fmt.Errorf("expected to create 1 resource, got %d", len(data.Items))
+
+	ids := map[string][]string{}
+	for _, obj := range objects {
+		exists := false
+		for _, live := range existing {
+			if obj.matches(live) {
+				exists = true
+				break
+			}
+		}
+		for _, verb := range resourceManifestApplyVerbs(d, exists) {
+			ids[verb] = append(ids[verb], obj.id())
+		}
 	}
-	selflink := data.Items[0].Metadata.Selflink
-	if selflink == "" {
-		return fmt.Errorf("could not parse self-link from response %s", stdout.String())
+	for _, verb := range []string{"create", "update", "patch", "delete"} {
+		if len(ids[verb]) == 0 {
+			continue
+		}
+		if err := checkPermissions(m, kubeconfig, verb, ids[verb], timeout); err != nil {
+			return err
+		}
 	}
-	d.SetId(selflink)
 	return nil
 }
 
-func resourceManifestUpdate(d *schema.ResourceData, m interface{}) error {
-	kubeconfig, cleanup, err := kubeconfigPath(m)
-	if err != nil {
-		return fmt.Errorf("determining kubeconfig: %v", err)
+// resourceManifestApplyVerbs returns the verbs the apply_method of the resource
+// performs on an object. kubectl create only creates objects, kubectl replace
+// --force updates existing ones by deleting and recreating them, and kubectl
+// apply creates new objects and patches existing ones.
+func resourceManifestApplyVerbs(d *schema.ResourceData, exists bool) []string {
+	if !exists {
+		return []string{"create"}
 	}
-	defer cleanup()
+	switch d.Get("apply_method").(string) {
+	case "create":
+		if d.IsNewResource() {
+			return []string{"create"}
+		}
+	case "replace":
+		return []string{"update", "delete", "create"}
+	}
+	return []string{"patch"}
+}
 
-	shouldValidate := d.Get("validate")
+// checkPermissions verifies that the user may perform verb on every object with
+// the given IDs (kubectl auth can-i), so that an operation doesn't fail halfway
+// with some of the objects changed already.
+func checkPermissions(m interface{}, kubeconfig kubeconfigFiles, verb string, ids []string, timeout time.Duration) error {
+	var forbidden []string
+	for _, id := range ids {
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", id)
+		}
+		// Objects without a name, e.g. using generateName, are checked by kind.
+		k8sResource = strings.TrimSuffix(k8sResource, "/")
+		args := []string{"auth", "can-i", verb, k8sResource}
+		if namespace != "" {
+			args = append(args, "-n", namespace)
+		}
 
-	return resource.Retry(d.Timeout(schema.TimeoutUpdate), func() *resource.RetryError {
-		args := []string{"apply", "-f", "-"}
-		if !shouldValidate.(bool) {
-			args = append(args, "--validate=false")
+		var allowed bool
+		err := retry(m, timeout, func() *resource.RetryError {
+			// kubectl auth can-i exits with 1 when the action isn't allowed.
+			_, code, err := runAllowingExitCodes(kubectl(m, kubeconfig, args...), 1)
+			if err != nil {
+				return retryError(err)
+			}
+			allowed = code == 0
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("checking the permission to %s %s: %v", verb, k8sResource, err)
 		}
-		cmd := kubectl(m, kubeconfig, args...)
-		cmd.Stdin = strings.NewReader(d.Get("content").(string))
-		if err := run(cmd); err != nil {
-			return resource.RetryableError(err)
+		if !allowed {
+			forbidden = append(forbidden, k8sResource)
 		}
-		return nil
-	})
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("not allowed to %s %s, none of the objects were changed", verb, strings.Join(forbidden, ", "))
+	}
+	return nil
 }
 
-func resourceFromSelflink(s string) (resource, namespace string, ok bool) {
-	parts := strings.Split(s, "/")
-	if len(parts) < 2 {
-		return "", "", false
-	}
-	resource = parts[len(parts)-2] + "/" + parts[len(parts)-1]
+// deleteBySelector deletes the objects matching the label selector which have
+// the kind and namespace of one of the objects with the given IDs, e.g. the
+// objects generated from the ones applied, in reverse order like deleteObjects.
+func deleteBySelector(m interface{}, kubeconfig kubeconfigFiles, ids []string, selector string, timeout time.Duration, extraArgs ...string) error {
+	deleted := map[string]bool{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		k8sResource, namespace, ok := resourceFromID(ids[i])
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", ids[i])
+		}
+		kind := strings.SplitN(k8sResource, "/", 2)[0]
+		if deleted[kind+"/"+namespace] {
+			continue
+		}
+		deleted[kind+"/"+namespace] = true
 
-	for i, part := range parts {
-		if part == "namespaces" && len(parts) > i+1 {
-			namespace = parts[i+1]
-			break
+		args := append([]string{"delete", kind, "-l", selector}, extraArgs...)
+		if namespace != "" {
+			args = append(args, "-n", namespace)
+		}
+		err := retry(m, timeout, func() *resource.RetryError {
+			if err := run(kubectl(m, kubeconfig, args...)); err != nil {
+				return retryError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
-	return resource, namespace, true
+	return nil
 }
 
-func resourceManifestDelete(d *schema.ResourceData, m interface{}) error {
-	k8sResource, namespace, ok := resourceFromSelflink(d.Id())
-	if !ok {
-		return fmt.Errorf("invalid resource id: %s", d.Id())
+// waitForDeletion polls the objects with the given IDs until they are gone, so
+// that objects with finalizers are deleted completely. Like waitForValue the
+// polling is only bounded by the timeout, not by the number of retries of failed
+// commands.
+func waitForDeletion(m interface{}, kubeconfig kubeconfigFiles, ids []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, id := range ids {
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", id)
+		}
+		args := []string{"get", "--ignore-not-found", "-o", "name", k8sResource}
+		if namespace != "" {
+			args = append(args, "-n", namespace)
+		}
+
+		err := resource.Retry(time.Until(deadline), func() *resource.RetryError {
+			cmd := kubectl(m, kubeconfig, args...)
+			stdout := &bytes.Buffer{}
+			cmd.Stdout = stdout
+			if err := run(cmd); err != nil {
+				return retryError(err)
+			}
+			if strings.TrimSpace(stdout.String()) != "" {
+				return resource.RetryableError(fmt.Errorf("%s still exists", k8sResource))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("waiting %s for the deletion of %s: %v", timeout, k8sResource, err)
+		}
 	}
-	args := []string{"delete", k8sResource}
-	if namespace != "" {
-		args = append(args, "-n", namespace)
+	return nil
+}
+
+// deleteObjects deletes the objects with the given IDs, passing extraArgs to
+// kubectl delete.
+func deleteObjects(m interface{}, kubeconfig kubeconfigFiles, ids []string, timeout time.Duration, extraArgs ...string) error {
+	// Delete in reverse order so that objects are removed before the ones
+	// they were applied after (e.g. a namespace after its contents).
+	for i := len(ids) - 1; i >= 0; i-- {
+		k8sResource, namespace, ok := resourceFromID(ids[i])
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", ids[i])
+		}
+		args := append([]string{"delete", k8sResource}, extraArgs...)
+		if namespace != "" {
+			args = append(args, "-n", namespace)
+		}
+
+		err := retry(m, timeout, func() *resource.RetryError {
+			cmd := kubectl(m, kubeconfig, args...)
+			if err := run(cmd); err != nil {
+				return retryError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func resourceManifestRead(d *schema.ResourceData, m interface{}) error {
+	m = resourceConfig(d, m)
 	kubeconfig, cleanup, err := kubeconfigPath(m)
 	if err != nil {
 		return fmt.Errorf("determining kubeconfig: %v", err)
 	}
 	defer cleanup()
 
-	return resource.Retry(d.Timeout(schema.TimeoutDelete), func() *resource.RetryError {
-		cmd := kubectl(m, kubeconfig, args...)
-		if err := run(cmd); err != nil {
-			return resource.RetryableError(err)
+	var live []map[string]interface{}
+	resourceVersions := map[string]interface{}{}
+	generations := map[string]interface{}{}
+	for _, id := range resourceManifestObjectIDs(d.Id()) {
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", d.Id())
 		}
-		return nil
-	})
-}
 
-func resourceManifestRead(d *schema.ResourceData, m interface{}) error {
-	k8sResource, namespace, ok := resourceFromSelflink(d.Id())
-	if !ok {
-		return fmt.Errorf("invalid resource id: %s", d.Id())
+		stdout, found, err := getObject(m, kubeconfig, k8sResource, namespace, "json", d.Timeout(schema.TimeoutRead))
+		if err != nil {
+			return err
+		}
+
+		// If any of the objects is gone the whole manifest has to be
+		// applied again.
+		if !found {
+			d.SetId("")
+			return nil
+		}
+
+		var object map[string]interface{}
+		if err := json.Unmarshal(stdout.Bytes(), &object); err != nil {
+			return fmt.Errorf("decoding response: %v", err)
+		}
+		if len(live) == 0 {
+			// The UID changes when the object is recreated outside of Terraform.
+			var first struct {
+				APIVersion string `json:"apiVersion"`
+				Kind       string `json:"kind"`
+				Metadata   struct {
+					Name string `json:"name"`
+					UID  string `json:"uid"`
+				} `json:"metadata"`
+			}
+			if err := json.Unmarshal(stdout.Bytes(), &first); err != nil {
+				return fmt.Errorf("decoding response: %v", err)
+			}
+			err := resourceManifestSetAttributes(d, first.APIVersion, first.Kind, first.Metadata.Name, first.Metadata.UID)
+			if err != nil {
+				return err
+			}
+			if err := setObjectStatus(d, object); err != nil {
+				return err
+			}
+		}
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			resourceVersions[id] = metadata["resourceVersion"]
+			if generation, ok := metadata["generation"].(float64); ok {
+				generations[id] = strconv.FormatInt(int64(generation), 10)
+			}
+		}
+		normalizeObject(object)
+		live = append(live, object)
+	}
+	if err := d.Set("resource_versions", resourceVersions); err != nil {
+		return fmt.Errorf("setting resource_versions: %v", err)
+	}
+	if err := d.Set("generations", generations); err != nil {
+		return fmt.Errorf("setting generations: %v", err)
 	}
 
-	args := []string{"get", "--ignore-not-found", k8sResource}
-	if namespace != "" {
-		args = append(args, "-n", namespace)
+	return resourceManifestDetectDrift(d, m, live)
+}
+
+// resourceManifestImport imports an existing object identified by
+// namespace/kind/name, or kind/name for cluster-scoped objects. The kind may be
+// qualified as understood by kubectl, e.g. deployment.v1.apps. The live object,
+// without its status and server-managed fields, becomes the content.
+func resourceManifestImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	var namespace, k8sResource string
+	switch parts := strings.Split(d.Id(), "/"); len(parts) {
+	case 2:
+		k8sResource = parts[0] + "/" + parts[1]
+	case 3:
+		namespace, k8sResource = parts[0], parts[1]+"/"+parts[2]
+	default:
+		return nil, fmt.Errorf("invalid import id %q, expected namespace/kind/name or kind/name", d.Id())
 	}
 
-	var stdout *bytes.Buffer
 	kubeconfig, cleanup, err := kubeconfigPath(m)
 	if err != nil {
-		return fmt.Errorf("determining kubeconfig: %v", err)
+		return nil, fmt.Errorf("determining kubeconfig: %v", err)
 	}
 	defer cleanup()
 
-	err = resource.Retry(d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
+	args := []string{"get", "-o", "json", k8sResource}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, d.Timeout(schema.TimeoutRead), func() *resource.RetryError {
 		cmd := kubectl(m, kubeconfig, args...)
 		stdout = &bytes.Buffer{}
 		cmd.Stdout = stdout
 		if err := run(cmd); err != nil {
-			return resource.RetryableError(err)
+			return retryError(err)
 		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if strings.TrimSpace(stdout.String()) == "" {
-		d.SetId("")
+	var object map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &object); err != nil {
+		return nil, fmt.Errorf("decoding response: %v", err)
 	}
-	return nil
+	normalizeObject(object)
+
+	content, err := encodeManifest([]interface{}{object})
+	if err != nil {
+		return nil, fmt.Errorf("encoding manifest: %v", err)
+	}
+
+	apiVersion, _ := object["apiVersion"].(string)
+	kind, _ := object["kind"].(string)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	objectNamespace, _ := metadata["namespace"].(string)
+	d.SetId(manifestObject{apiVersion, kind, objectNamespace, name}.id())
+
+	if err := d.Set("content", content); err != nil {
+		return nil, fmt.Errorf("setting content: %v", err)
+	}
+	if err := d.Set("content_hash", manifestHash(content)); err != nil {
+		return nil, fmt.Errorf("setting content_hash: %v", err)
+	}
+	// The state of an imported resource starts out empty, so the defaults have
+	// to be set to avoid a difference on the next plan. The namespace is only
+	// kept in the ID and the content, since setting the namespace argument,
+	// which forces a new resource, would replace resources configured without
+	// it.
+	for key, s := range resourceManifest().Schema {
+		if s.Default != nil {
+			if err := d.Set(key, s.Default); err != nil {
+				return nil, fmt.Errorf("setting %s: %v", key, err)
+			}
+		}
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// resourceManifestDetectDrift compares the live objects to the ones described
+// by the manifest. When they differ content_hash is replaced with the checksum
+// of the live objects, so that the next plan applies the manifest again. The
+// arguments the manifest is read from are left alone.
+func resourceManifestDetectDrift(d *schema.ResourceData, m interface{}, live []map[string]interface{}) error {
+	content, err := resourceManifestContent(d, m)
+	if err != nil {
+		return nil
+	}
+	desired, err := decodeManifest(content)
+	if err != nil || len(desired) != len(live) {
+		// The objects can't be paired up, leave the content untouched.
+		return nil
+	}
+
+	drifted := false
+	observed := make([]interface{}, len(live))
+	for i := range live {
+		// The live objects are normalized, so the status and the
+		// last-applied-configuration annotation a manifest may carry, e.g.
+		// one rendered by helm, aren't compared either.
+		normalizeObject(desired[i])
+		observed[i] = projectObject(live[i], desired[i])
+		for _, field := range d.Get("ignore_fields").([]interface{}) {
+			observed[i] = maskField(observed[i], desired[i], fieldPath(field.(string)))
+		}
+		if !reflect.DeepEqual(observed[i], desired[i]) {
+			drifted = true
+		}
+	}
+	if !drifted {
+		return nil
+	}
+
+	content, err = encodeManifest(observed)
+	if err != nil {
+		return fmt.Errorf("encoding live objects: %v", err)
+	}
+	return d.Set("content_hash", manifestHash(content))
 }
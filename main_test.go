@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryError(t *testing.T) {
+	for message, retryable := range map[string]bool{
+		`The Deployment "a" is invalid: spec.template.spec.containers[0].readinessProbe.timeoutSeconds: Invalid value: -1`: false,
+		`error validating data: ValidationError(Deployment.spec): unknown field "timeoutSecond"`:                           false,
+		`Apply failed with 1 conflict: conflict with "kubectl-client-side-apply" using apps/v1: .spec.replicas`:            false,
+		`dial tcp 10.0.0.1:443: i/o timeout`:                                                  true,
+		`Operation cannot be fulfilled on deployments.apps "a": the object has been modified`: true,
+	} {
+		rerr := retryError(&commandError{err: errors.New("exit status 1"), stderr: message})
+		if rerr.Retryable != retryable {
+			t.Errorf("retryable = %v for %q, want %v", rerr.Retryable, message, retryable)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"sigs.k8s.io/yaml"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// decodeManifest splits content into its YAML (or JSON) documents and decodes
+// every non-empty document into an object.
+func decodeManifest(content string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for i, document := range documentSeparator.Split(content, -1) {
+		data, err := yaml.YAMLToJSON([]byte(document))
+		if err != nil {
+			return nil, fmt.Errorf("parsing document %d: %v", i+1, err)
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, fmt.Errorf("decoding document %d: %v", i+1, err)
+		}
+		if object == nil {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// fetchManifest returns the manifest at url, which may contain multiple
+// documents. Manifests are fetched while planning as well, where there's no
+// operation timeout, so the request is bounded by defaultReadTimeout.
+func fetchManifest(url string) (string, error) {
+	resp, err := httpGet(url, defaultReadTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// httpGet requests url, giving up after timeout including the time it takes to
+// read the body. Responses with a status other than 2xx are returned as errors.
+func httpGet(url string, timeout time.Duration) (*http.Response, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// manifestHash returns the SHA-256 checksum of a manifest. The decoded objects
+// are hashed in a canonical encoding, so that manifests which only differ in
+// their formatting have the same checksum. Manifests which can't be decoded are
+// hashed as they are.
+func manifestHash(content string) string {
+	data := []byte(content)
+	if objects, err := decodeManifest(content); err == nil {
+		if canonical, err := json.Marshal(objects); err == nil {
+			data = canonical
+		}
+	}
+	return rawManifestHash(string(data))
+}
+
+// rawManifestHash returns the SHA-256 checksum of the text of a manifest, as
+// recorded by earlier versions of the provider.
+func rawManifestHash(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+// suppressEquivalentManifest suppresses the difference between manifests
+// describing the same objects, e.g. when only the indentation changed.
+func suppressEquivalentManifest(k, old, new string, d *schema.ResourceData) bool {
+	oldObjects, err := decodeManifest(old)
+	if err != nil {
+		return false
+	}
+	newObjects, err := decodeManifest(new)
+	if err != nil {
+		return false
+	}
+	return len(oldObjects) > 0 && reflect.DeepEqual(oldObjects, newObjects)
+}
+
+// decodeBase64 returns the base64 encoded content, or an empty string if it
+// isn't valid base64.
+func decodeBase64(encoded string) string {
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// suppressEquivalentBase64Manifest is suppressEquivalentManifest for base64
+// encoded manifests.
+func suppressEquivalentBase64Manifest(k, old, new string, d *schema.ResourceData) bool {
+	return suppressEquivalentManifest(k, decodeBase64(old), decodeBase64(new), d)
+}
+
+// encodeManifest encodes objects as YAML documents.
+func encodeManifest(objects []interface{}) (string, error) {
+	documents := make([]string, 0, len(objects))
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, string(data))
+	}
+	return strings.Join(documents, "---\n"), nil
+}
+
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// substituteVariables replaces the ${NAME} placeholders of the manifest with the
+// values of vars, like envsubst. Placeholders of variables missing from vars
+// are left alone.
+func substituteVariables(content string, vars map[string]string) string {
+	return variablePattern.ReplaceAllStringFunc(content, func(placeholder string) string {
+		if value, ok := vars[variablePattern.FindStringSubmatch(placeholder)[1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// annotateManifest sets the annotation key to value on every object of the
+// manifest.
+func annotateManifest(content, key, value string) (string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return "", err
+	}
+	documents := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		metadata, ok := object["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			object["metadata"] = metadata
+		}
+		annotations, ok := metadata["annotations"].(map[string]interface{})
+		if !ok {
+			annotations = map[string]interface{}{}
+			metadata["annotations"] = annotations
+		}
+		annotations[key] = value
+		documents = append(documents, object)
+	}
+	return encodeManifest(documents)
+}
+
+// overrideNamespace replaces the namespace of the objects of the manifest which
+// set a different one, so that it doesn't conflict with kubectl's -n flag.
+// Objects without a namespace are left alone, as they may be cluster-scoped.
+// The manifest is returned unchanged if no object sets a different namespace.
+func overrideNamespace(content, namespace string) (string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return "", err
+	}
+	changed := false
+	documents := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			if ns, ok := metadata["namespace"].(string); ok && ns != "" && ns != namespace {
+				metadata["namespace"] = namespace
+				changed = true
+			}
+		}
+		documents = append(documents, object)
+	}
+	if !changed {
+		return content, nil
+	}
+	return encodeManifest(documents)
+}
+
+// podSpecPaths are the paths of the pod specs within the objects of the kinds
+// running pods.
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// addImagePullSecrets adds the named secrets to the imagePullSecrets of the pod
+// specs of the manifest, keeping the ones already listed. The manifest is
+// returned unchanged if it doesn't run pods.
+func addImagePullSecrets(content string, secrets []string) (string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return "", err
+	}
+	changed := false
+	documents := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		kind, _ := object["kind"].(string)
+		if path, ok := podSpecPaths[kind]; ok {
+			spec := object
+			for _, key := range path {
+				next, ok := spec[key].(map[string]interface{})
+				if !ok {
+					next = map[string]interface{}{}
+					spec[key] = next
+				}
+				spec = next
+			}
+			listed := map[string]bool{}
+			pullSecrets, _ := spec["imagePullSecrets"].([]interface{})
+			for _, secret := range pullSecrets {
+				if secret, ok := secret.(map[string]interface{}); ok {
+					name, _ := secret["name"].(string)
+					listed[name] = true
+				}
+			}
+			for _, name := range secrets {
+				if !listed[name] {
+					pullSecrets = append(pullSecrets, map[string]interface{}{"name": name})
+					listed[name] = true
+				}
+			}
+			spec["imagePullSecrets"] = pullSecrets
+			changed = true
+		}
+		documents = append(documents, object)
+	}
+	if !changed {
+		return content, nil
+	}
+	return encodeManifest(documents)
+}
+
+// fanOutNamespaces repeats the objects of the manifest for every namespace,
+// setting their namespace. Objects of the clusterScopedKinds are only included
+// once.
+func fanOutNamespaces(content string, namespaces []string) (string, error) {
+	var documents []interface{}
+	for i, namespace := range namespaces {
+		// The objects are decoded for every namespace to get copies.
+		objects, err := decodeManifest(content)
+		if err != nil {
+			return "", err
+		}
+		for _, object := range objects {
+			if kind, _ := object["kind"].(string); clusterScopedKinds[kind] {
+				if i == 0 {
+					documents = append(documents, object)
+				}
+				continue
+			}
+			metadata, ok := object["metadata"].(map[string]interface{})
+			if !ok {
+				metadata = map[string]interface{}{}
+				object["metadata"] = metadata
+			}
+			metadata["namespace"] = namespace
+			documents = append(documents, object)
+		}
+	}
+	return encodeManifest(documents)
+}
+
+// manifestDropsObjects reports whether an object with one of the given IDs is
+// missing from the manifest, e.g. because its kind changed or it moved to
+// another namespace. Objects which don't set a namespace match the object with
+// the same name in any namespace. Object IDs of earlier versions of the provider
+// and manifests which can't be decoded are not compared.
+func manifestDropsObjects(ids []string, content string) bool {
+	described, err := manifestObjects(content, "")
+	if err != nil {
+		return false
+	}
+	for _, id := range ids {
+		if strings.HasPrefix(id, "/") {
+			continue
+		}
+		existing, ok := parseManifestObject(id)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, obj := range described {
+			if obj.matches(existing) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultNamespace sets the namespace of the namespaced objects of the manifest
+// which don't set one. The manifest is returned unchanged if every object sets
+// its namespace.
+func defaultNamespace(content, namespace string) (string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return "", err
+	}
+	changed := false
+	documents := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		kind, _ := object["kind"].(string)
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok && !clusterScopedKinds[kind] {
+			if ns, _ := metadata["namespace"].(string); ns == "" {
+				metadata["namespace"] = namespace
+				changed = true
+			}
+		}
+		documents = append(documents, object)
+	}
+	if !changed {
+		return content, nil
+	}
+	return encodeManifest(documents)
+}
+
+// kindOrder is the order in which Helm installs the objects of a chart, so that
+// objects are applied after the ones they depend on. Other kinds go last.
+var kindOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// sortManifest sorts the objects of the manifest by kindOrder. Objects of the
+// same kind keep their order.
+func sortManifest(content string) (string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return "", err
+	}
+	rank := func(object map[string]interface{}) int {
+		kind, _ := object["kind"].(string)
+		for i, k := range kindOrder {
+			if k == kind {
+				return i
+			}
+		}
+		return len(kindOrder)
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return rank(objects[i]) < rank(objects[j])
+	})
+	documents := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		documents = append(documents, object)
+	}
+	return encodeManifest(documents)
+}
+
+// firstPhaseKinds are the kinds applied before the other objects of a manifest.
+var firstPhaseKinds = map[string]bool{
+	"Namespace":                true,
+	"CustomResourceDefinition": true,
+}
+
+// manifestPhases splits the manifest into the objects of firstPhaseKinds and
+// the others, keeping their order. The manifest is returned as a single phase if
+// it doesn't contain both.
+func manifestPhases(content string) ([]string, error) {
+	objects, err := decodeManifest(content)
+	if err != nil {
+		return nil, err
+	}
+	var first, rest []interface{}
+	for _, object := range objects {
+		if kind, _ := object["kind"].(string); firstPhaseKinds[kind] {
+			first = append(first, object)
+		} else {
+			rest = append(rest, object)
+		}
+	}
+	if len(first) == 0 || len(rest) == 0 {
+		return []string{content}, nil
+	}
+
+	var phases []string
+	for _, objects := range [][]interface{}{first, rest} {
+		phase, err := encodeManifest(objects)
+		if err != nil {
+			return nil, err
+		}
+		phases = append(phases, phase)
+	}
+	return phases, nil
+}
+
+// serverManagedFields are the metadata fields set by the API server which never
+// appear in a manifest.
+var serverManagedFields = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// lastAppliedAnnotation is the annotation kubectl apply records the applied
+// manifest in.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// normalizeObject removes the status, the server-managed fields and the
+// last-applied-configuration annotation from a live object, which are never
+// part of a manifest.
+func normalizeObject(object map[string]interface{}) {
+	delete(object, "status")
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, field := range serverManagedFields {
+		delete(metadata, field)
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		delete(annotations, lastAppliedAnnotation)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+}
+
+// projectObject returns the parts of the live object that are also set in the
+// desired one, so that fields defaulted by the API server don't show up as
+// differences. Lists are only projected element by element when their length
+// matches, otherwise the live list is returned as is.
+func projectObject(live, desired interface{}) interface{} {
+	switch desired := desired.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		projected := make(map[string]interface{}, len(desired))
+		for key, value := range desired {
+			liveValue, ok := liveMap[key]
+			if !ok {
+				if value == nil {
+					projected[key] = nil
+				}
+				continue
+			}
+			projected[key] = projectObject(liveValue, value)
+		}
+		return projected
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok || len(liveList) != len(desired) {
+			return live
+		}
+		projected := make([]interface{}, len(desired))
+		for i := range desired {
+			projected[i] = projectObject(liveList[i], desired[i])
+		}
+		return projected
+	}
+	return live
+}
+
+// maskField replaces the value at path in the observed object with the one in
+// the desired object (or removes it if the desired object doesn't set it), so
+// that the field is ignored when the two are compared. Lists along the path are
+// masked element by element, e.g. spec.template.spec.containers.image masks the
+// image of every container.
+func maskField(observed, desired interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return desired
+	}
+	switch observed := observed.(type) {
+	case map[string]interface{}:
+		desiredMap, _ := desired.(map[string]interface{})
+		key := path[0]
+		desiredValue, isDesired := desiredMap[key]
+		if len(path) == 1 {
+			if isDesired {
+				observed[key] = desiredValue
+			} else {
+				delete(observed, key)
+			}
+		} else if observedValue, ok := observed[key]; ok {
+			observed[key] = maskField(observedValue, desiredValue, path[1:])
+		}
+		return observed
+	case []interface{}:
+		desiredList, _ := desired.([]interface{})
+		for i := range observed {
+			var desiredValue interface{}
+			if i < len(desiredList) {
+				desiredValue = desiredList[i]
+			}
+			observed[i] = maskField(observed[i], desiredValue, path)
+		}
+		return observed
+	}
+	return observed
+}
+
+// fieldPath splits a field path such as spec.replicas (or .spec.replicas) into
+// its keys.
+func fieldPath(field string) []string {
+	return strings.Split(strings.TrimPrefix(field, "."), ".")
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestManifestHashIgnoresFormatting(t *testing.T) {
+	applied := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"
+	reformatted := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n    name: a\n\n"
+	if manifestHash(applied) != manifestHash(reformatted) {
+		t.Errorf("formatting-only change changed the checksum")
+	}
+
+	changed := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n"
+	if manifestHash(applied) == manifestHash(changed) {
+		t.Errorf("changed name kept the checksum")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func resourceManifestList() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceManifestListCreate,
+		Read:   resourceManifestListRead,
+		Update: resourceManifestListUpdate,
+		Delete: resourceManifestListDelete,
+
+		CustomizeDiff: resourceManifestListCustomizeDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultReadTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"directory": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"directory", "paths"},
+			},
+			"paths": &schema.Schema{
+				Type:         schema.TypeList,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				ExactlyOneOf: []string{"directory", "paths"},
+			},
+			"namespace": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"content_hash": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// resourceManifestListPaths returns the configured directory or paths.
+func resourceManifestListPaths(d resourceGetter) []string {
+	if directory := d.Get("directory").(string); directory != "" {
+		return []string{directory}
+	}
+	var paths []string
+	for _, path := range d.Get("paths").([]interface{}) {
+		paths = append(paths, path.(string))
+	}
+	return paths
+}
+
+// resourceManifestListHash returns the SHA-256 checksum of the manifest files,
+// i.e. of the files kubectl apply --recursive reads. Paths may also be URLs,
+// which are fetched.
+func resourceManifestListHash(paths []string) (string, error) {
+	hash := sha256.New()
+	for _, path := range paths {
+		if strings.Contains(path, "://") {
+			content, err := fetchManifest(path)
+			if err != nil {
+				return "", fmt.Errorf("fetching %s: %v", path, err)
+			}
+			fmt.Fprintf(hash, "%s\n%s\n", path, content)
+			continue
+		}
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(file)
+			if info.IsDir() || file != path && ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				return nil
+			}
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\n%s\n", file, content)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// resourceManifestListCustomizeDiff plans an update when the content of the
+// manifest files changed, which Terraform can't tell from the arguments. The
+// resource is replaced when its objects would be applied into another
+// namespace, because the default namespace of the provider changed.
+func resourceManifestListCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	if resourceManifestListMoves(d, m) {
+		if err := d.SetNewComputed("content_hash"); err != nil {
+			return err
+		}
+		return d.ForceNew("content_hash")
+	}
+	if !d.NewValueKnown("directory") || !d.NewValueKnown("paths") {
+		return nil
+	}
+	hash, err := resourceManifestListHash(resourceManifestListPaths(d))
+	if err != nil {
+		return fmt.Errorf("hashing manifest files: %v", err)
+	}
+	if hash != d.Get("content_hash").(string) {
+		return d.SetNew("content_hash", hash)
+	}
+	return nil
+}
+
+// resourceManifestListMoves reports whether one of the tracked namespaced
+// objects isn't in the namespace the files are applied into. kubectl rejects
+// objects of another namespace than the one passed with -n, so they can only be
+// in another one when the namespace changed.
+func resourceManifestListMoves(d *schema.ResourceDiff, m interface{}) bool {
+	if !d.NewValueKnown("namespace") {
+		return false
+	}
+	namespace := resourceNamespace(d, m)
+	if namespace == "" {
+		return false
+	}
+	for _, id := range resourceManifestObjectIDs(d.Id()) {
+		if strings.HasPrefix(id, "/") {
+			continue
+		}
+		if obj, ok := parseManifestObject(id); ok && obj.namespace != "" && obj.namespace != namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceManifestListFileArgs returns the kubectl arguments selecting the
+// manifest files of the resource.
+func resourceManifestListFileArgs(d *schema.ResourceData, m interface{}) []string {
+	args := []string{"--recursive"}
+	for _, path := range resourceManifestListPaths(d) {
+		args = append(args, "-f", path)
+	}
+	if namespace := resourceNamespace(d, m); namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	return args
+}
+
+func resourceManifestListCreate(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	return resourceManifestListApply(d, m, kubeconfig, d.Timeout(schema.TimeoutCreate))
+}
+
+func resourceManifestListUpdate(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	return resourceManifestListApply(d, m, kubeconfig, d.Timeout(schema.TimeoutUpdate))
+}
+
+// resourceManifestListApply applies the manifest files and stores the IDs of
+// the objects they describe as the resource ID.
+func resourceManifestListApply(d *schema.ResourceData, m interface{}, kubeconfig kubeconfigFiles, timeout time.Duration) error {
+	fileArgs := resourceManifestListFileArgs(d, m)
+	hash, err := resourceManifestListHash(resourceManifestListPaths(d))
+	if err != nil {
+		return fmt.Errorf("hashing manifest files: %v", err)
+	}
+
+	err = retry(m, timeout, func() *resource.RetryError {
+		cmd := kubectl(m, kubeconfig, append([]string{"apply"}, fileArgs...)...)
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var stdout *bytes.Buffer
+	err = retry(m, timeout, func() *resource.RetryError {
+		stdout = &bytes.Buffer{}
+		cmd := kubectl(m, kubeconfig, append([]string{"get", "--no-headers", "-o", objectColumns}, fileArgs...)...)
+		cmd.Stdout = stdout
+		if err := run(cmd); err != nil {
+			return retryError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := parseObjectList(stdout.String())
+	if err != nil {
+		return err
+	}
+	ids, err := data.ids()
+	if err != nil {
+		return err
+	}
+	d.SetId(strings.Join(ids, ","))
+	return d.Set("content_hash", hash)
+}
+
+func resourceManifestListRead(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	for _, id := range resourceManifestObjectIDs(d.Id()) {
+		k8sResource, namespace, ok := resourceFromID(id)
+		if !ok {
+			return fmt.Errorf("invalid resource id: %s", d.Id())
+		}
+
+		_, found, err := getObject(m, kubeconfig, k8sResource, namespace, "name", d.Timeout(schema.TimeoutRead))
+		if err != nil {
+			return err
+		}
+
+		// If any of the objects is gone the files have to be applied again.
+		if !found {
+			d.SetId("")
+			return nil
+		}
+	}
+	return nil
+}
+
+func resourceManifestListDelete(d *schema.ResourceData, m interface{}) error {
+	kubeconfig, cleanup, err := kubeconfigPath(m)
+	if err != nil {
+		return fmt.Errorf("determining kubeconfig: %v", err)
+	}
+	defer cleanup()
+
+	// The tracked objects are deleted rather than the ones described by the
+	// files, which may have changed or been removed since they were applied.
+	return deleteObjects(m, kubeconfig, resourceManifestObjectIDs(d.Id()), d.Timeout(schema.TimeoutDelete))
+}